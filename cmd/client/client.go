@@ -1,36 +1,81 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-var target = flag.String("target", "http://localhost:8090", "request target")
+var (
+	target      = flag.String("target", "http://localhost:8090", "request target")
+	concurrency = flag.Int("concurrency", 1, "number of concurrent workers, or of requests in flight during a replay")
+	rps         = flag.Float64("rps", 1, "requests per second across all workers, 0 for as many as possible")
+	duration    = flag.Duration("duration", 0, "how long to send requests, until interrupted when 0")
+	timeout     = flag.Duration("timeout", 10*time.Second, "request timeout")
+	paths       = flag.String("paths", "random", "path distribution: random or zipf")
+	pathsFile   = flag.String("paths-file", "", "file with a path per line to pick from uniformly, instead of -paths")
+	zipfKeys    = flag.Int("zipf-keys", 1000, "number of distinct paths of the zipf distribution")
+	zipfSkew    = flag.Float64("zipf-skew", 1.1, "skew of the zipf distribution, above 1")
+	author      = flag.String("author", "client", "prefix of the lb-author header tagging the requests of each worker, none when empty")
+	verbose     = flag.Bool("v", false, "whether to log every response")
+
+	replayFile = flag.String("replay", "", "JSONL request log to replay instead of generating load")
+	speed      = flag.Float64("speed", 1, "replay speed multiplier, 0 to send requests as fast as possible")
+)
 
 func main() {
 	flag.Parse()
+	if err := validateLoad(*concurrency, *rps, *speed); err != nil {
+		log.Fatal(err)
+	}
 	client := new(http.Client)
-	client.Timeout = 10 * time.Second
-
-	for range time.Tick(1 * time.Second) {
-		rroute := GenerateRandomRoute()
-		resp, err := client.Get(fmt.Sprintf("%s/%s", *target, rroute))
-		if err == nil {
-			log.Printf("response %d", resp.StatusCode)
-		} else {
-			log.Printf("error %s", err)
+	client.Timeout = *timeout
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	if *replayFile != "" {
+		f, err := os.Open(*replayFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		stats, err := replay(ctx, client, *target, f, *speed, *concurrency, *verbose)
+		stats.print(os.Stdout)
+		if err != nil {
+			log.Fatalf("Replay stopped: %s", err)
 		}
+		return
 	}
+
+	source, err := newPathSource(*paths, *pathsFile, *zipfKeys, *zipfSkew)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats := runLoad(ctx, client, loadConfig{
+		target:      *target,
+		concurrency: *concurrency,
+		rps:         *rps,
+		paths:       source,
+		author:      *author,
+		verbose:     *verbose,
+	})
+	stats.print(os.Stdout)
 }
 
 // Function to generate random URL routes (used for load balancer test)
 func GenerateRandomRoute() string {
-	rand.Seed(time.Now().UnixNano())
-
 	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
 
 	length := rand.Intn(8) + 3
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// loadConfig describes the load to generate. Requests are sent by concurrency workers, at most rps per
+// second in total, or as fast as the workers can without a rate. With an author, each worker tags its
+// requests with lb-author <author>-<worker> and counts them in lb-req-cnt, which the servers report.
+type loadConfig struct {
+	target      string
+	concurrency int
+	rps         float64
+	paths       pathSource
+	author      string
+	verbose     bool
+}
+
+// validateLoad checks the -concurrency, -rps and -speed flags.
+func validateLoad(concurrency int, rps, speed float64) error {
+	if concurrency < 1 {
+		return fmt.Errorf("-concurrency must be at least 1, got %d", concurrency)
+	}
+	if _, ok := tickPeriod(rps); rps != 0 && !ok {
+		return fmt.Errorf("-rps must be 0 or between a request per nanosecond and one per %s, got %g",
+			time.Duration(math.MaxInt64), rps)
+	}
+	if !(speed >= 0 && !math.IsInf(speed, 1)) {
+		return fmt.Errorf("-speed must be 0 or positive, got %g", speed)
+	}
+	return nil
+}
+
+// tickPeriod returns the time between requests sent at the rate, or false if it doesn't fit a
+// time.Duration.
+func tickPeriod(rps float64) (time.Duration, bool) {
+	period := float64(time.Second) / rps
+	if !(period >= 1 && period < math.MaxInt64) {
+		return 0, false
+	}
+	return time.Duration(period), true
+}
+
+// runLoad sends requests until the context is done and returns their statistics.
+func runLoad(ctx context.Context, client *http.Client, cfg loadConfig) *loadStats {
+	stats := newLoadStats()
+	var ticks <-chan time.Time
+	if period, ok := tickPeriod(cfg.rps); ok {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for worker := range cfg.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			author := fmt.Sprintf("%s-%d", cfg.author, worker)
+			for counter := 1; ; counter++ {
+				// Ticks that come while all workers are busy are dropped, so a slow target gets fewer requests
+				// instead of a burst once it recovers.
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.target+"/"+cfg.paths.next(), nil)
+				if err != nil {
+					// The target is malformed, so every request would fail the same way.
+					stats.record(result{err: err})
+					return
+				}
+				if cfg.author != "" {
+					req.Header.Set("lb-author", author)
+					req.Header.Set("lb-req-cnt", strconv.Itoa(counter))
+				}
+				stats.record(send(ctx, client, req, cfg.verbose))
+			}
+		}()
+	}
+	wg.Wait()
+	stats.finish()
+	return stats
+}
+
+// result of a single request. Backend is read from the lb-from header, set by the balancer with -trace.
+type result struct {
+	latency time.Duration
+	status  int
+	backend string
+	err     error
+}
+
+func send(ctx context.Context, client *http.Client, req *http.Request, verbose bool) result {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		// Requests interrupted by the end of the run aren't failures of the target.
+		if ctx.Err() != nil {
+			return result{err: context.Canceled}
+		}
+		if verbose {
+			log.Printf("error %s", err)
+		}
+		return result{latency: time.Since(start), err: err}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	r := result{latency: time.Since(start), status: resp.StatusCode, backend: resp.Header.Get("lb-from")}
+	if verbose {
+		log.Printf("response %d from %s in %s", r.status, r.backend, r.latency)
+	}
+	return r
+}
+
+// errorKind groups errors by cause, leaving out the URL that differs between requests.
+func errorKind(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return fmt.Sprintf("%s: %s", opErr.Op, opErr.Err)
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPathSources(t *testing.T) {
+	if _, err := newPathSource("pareto", "", 0, 0); err == nil {
+		t.Error("Unknown distribution was accepted")
+	}
+	if _, err := newPathSource("zipf", "", 10, 1); err == nil {
+		t.Error("Zipf skew of 1 was accepted")
+	}
+
+	zipf, err := newPathSource("zipf", "", 100, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for range 10000 {
+		counts[zipf.next()]++
+	}
+	if top := counts[zipf.(*zipfPaths).paths[0]]; top < 3000 {
+		t.Errorf("Most popular path got only %d requests", top)
+	}
+
+	file := filepath.Join(t.TempDir(), "paths")
+	if err := os.WriteFile(file, []byte("# popular\n/api/v1/some-data\n\nhome\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := newPathSource("random", file, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 100 {
+		if path := source.next(); path != "api/v1/some-data" && path != "home" {
+			t.Fatalf("Unexpected path %q", path)
+		}
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	var requests atomic.Int64
+	var authors sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		authors.Store(r.Header.Get("lb-author"), r.Header.Get("lb-req-cnt"))
+		rw.Header().Set("lb-from", []string{"server1:8080", "server2:8080"}[n%2])
+		if n%5 == 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stats := runLoad(ctx, server.Client(), loadConfig{target: server.URL, concurrency: 4, paths: randomPaths{}, author: "test"})
+
+	for worker := range 4 {
+		if counter, ok := authors.Load(fmt.Sprintf("test-%d", worker)); !ok || counter == "" {
+			t.Errorf("No tagged requests from worker %d", worker)
+		}
+	}
+	total := 0
+	for _, n := range stats.backends {
+		total += n
+	}
+	if total == 0 || total != len(stats.latencies) || int64(total) > requests.Load() {
+		t.Errorf("Recorded %d responses, %d latencies, %d requests", total, len(stats.latencies), requests.Load())
+	}
+	if stats.statuses[http.StatusOK] == 0 || stats.statuses[http.StatusServiceUnavailable] == 0 {
+		t.Errorf("Unexpected statuses %v", stats.statuses)
+	}
+	if len(stats.backends) != 2 || len(stats.errors) != 0 {
+		t.Errorf("Unexpected backends %v and errors %v", stats.backends, stats.errors)
+	}
+
+	var out bytes.Buffer
+	stats.print(&out)
+	for _, expected := range []string{"Requests:", "p99", "Status:", "  503", "Backends:", "  server1:8080"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Summary misses %q:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestRunLoadRate(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	runLoad(ctx, server.Client(), loadConfig{target: server.URL, concurrency: 8, rps: 50, paths: randomPaths{}})
+	if n := requests.Load(); n < 5 || n > 16 {
+		t.Errorf("Sent %d requests at 50 rps in 300ms", n)
+	}
+}
+
+func TestRunLoadErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats := runLoad(ctx, server.Client(), loadConfig{target: server.URL, concurrency: 1, rps: 100, paths: randomPaths{}})
+	if len(stats.errors) != 1 {
+		t.Errorf("Unexpected errors %v", stats.errors)
+	}
+	for kind := range stats.errors {
+		if !strings.Contains(kind, "dial") {
+			t.Errorf("Unexpected error kind %q", kind)
+		}
+	}
+}
+
+func TestValidateLoad(t *testing.T) {
+	for _, valid := range []struct {
+		concurrency int
+		rps, speed  float64
+	}{{1, 0, 1}, {8, 0.5, 0}, {1, 1e9, 2}, {1, 1e-9, 0.5}} {
+		if err := validateLoad(valid.concurrency, valid.rps, valid.speed); err != nil {
+			t.Errorf("Unexpected error for %v: %s", valid, err)
+		}
+	}
+	for _, invalid := range []struct {
+		concurrency int
+		rps, speed  float64
+	}{
+		{0, 1, 1}, {-1, 1, 1}, {1, -1, 1}, {1, 2e9, 1}, {1, 1e-10, 1}, {1, math.Inf(1), 1}, {1, math.NaN(), 1},
+		{1, 1, -1}, {1, 1, math.NaN()}, {1, 1, math.Inf(1)},
+	} {
+		if err := validateLoad(invalid.concurrency, invalid.rps, invalid.speed); err == nil {
+			t.Errorf("No error for %v", invalid)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{0.5: 50 * time.Millisecond, 0.99: 99 * time.Millisecond, 1: 100 * time.Millisecond, 0: time.Millisecond} {
+		if actual := percentile(sorted, p); actual != expected {
+			t.Errorf("p%g is %s, expected %s", p*100, actual, expected)
+		}
+	}
+	if percentile(nil, 0.5) != 0 {
+		t.Error("Percentile of no latencies isn't zero")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+)
+
+// pathSource chooses the path of each request. It must be safe for concurrent use.
+type pathSource interface {
+	next() string
+}
+
+func newPathSource(kind, file string, keys int, skew float64) (pathSource, error) {
+	if file != "" {
+		return readPaths(file)
+	}
+	switch kind {
+	case "random":
+		return randomPaths{}, nil
+	case "zipf":
+		if keys < 1 || skew <= 1 {
+			return nil, fmt.Errorf("zipf paths need at least one key and a skew above 1")
+		}
+		return newZipfPaths(keys, skew), nil
+	}
+	return nil, fmt.Errorf("unknown path distribution %q", kind)
+}
+
+// randomPaths never repeat, so requests spread over servers as evenly as the balancer can.
+type randomPaths struct{}
+
+func (randomPaths) next() string {
+	return GenerateRandomRoute()
+}
+
+// zipfPaths picks from a fixed set of paths where a few are requested most of the time, like
+// popular pages. The higher the skew, the more requests go to the most popular paths.
+type zipfPaths struct {
+	mu    sync.Mutex
+	zipf  *rand.Zipf
+	paths []string
+}
+
+func newZipfPaths(keys int, skew float64) *zipfPaths {
+	paths := make([]string, keys)
+	for i := range paths {
+		paths[i] = GenerateRandomRoute()
+	}
+	r := rand.New(rand.NewSource(rand.Int63()))
+	return &zipfPaths{zipf: rand.NewZipf(r, skew, 1, uint64(keys-1)), paths: paths}
+}
+
+func (z *zipfPaths) next() string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.paths[z.zipf.Uint64()]
+}
+
+// filePaths are read from a file with a path per line, skipping blank lines and # comments,
+// and picked uniformly.
+type filePaths []string
+
+func readPaths(name string) (filePaths, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths filePaths
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, strings.TrimPrefix(line, "/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s has no paths", name)
+	}
+	return paths, nil
+}
+
+func (p filePaths) next() string {
+	return p[rand.Intn(len(p))]
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordedRequest is a line of a captured traffic log. Status is the one the request got when it was
+// recorded, if known.
+type recordedRequest struct {
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+	Timestamp time.Time         `json:"timestamp"`
+	Status    int               `json:"status"`
+}
+
+// maxRecordLine bounds the length of a line of the traffic log, bodies included.
+const maxRecordLine = 1 << 20
+
+// replay re-issues the recorded requests against the target, keeping the gaps between their timestamps
+// divided by speed, or as fast as possible with a zero speed. Up to concurrency requests are in flight,
+// so a slow response doesn't delay the ones after it until all of them are taken. The log is read as
+// the replay goes.
+func replay(ctx context.Context, client *http.Client, target string, records io.Reader, speed float64, concurrency int, verbose bool) (*loadStats, error) {
+	stats := newLoadStats()
+	inFlight := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		stats.finish()
+	}()
+
+	scanner := bufio.NewScanner(records)
+	scanner.Buffer(make([]byte, 64<<10), maxRecordLine)
+	var first time.Time
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+		if first.IsZero() {
+			first = record.Timestamp
+		}
+		if speed > 0 {
+			due := time.Duration(float64(record.Timestamp.Sub(first)) / speed)
+			if wait := due - time.Since(stats.started); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return stats, nil
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return stats, nil
+		}
+
+		req, err := record.request(ctx, target)
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return stats, nil
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+			r := send(ctx, client, req, verbose)
+			stats.record(r)
+			if mismatch := record.mismatch(r); mismatch != "" {
+				stats.recordMismatch(mismatch)
+				if verbose {
+					log.Printf("%s %s: %s", record.Method, record.Path, mismatch)
+				}
+			}
+		}()
+	}
+	return stats, scanner.Err()
+}
+
+func (rr *recordedRequest) request(ctx context.Context, target string) (*http.Request, error) {
+	method := rr.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := rr.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target+path, strings.NewReader(rr.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range rr.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	return req, nil
+}
+
+// mismatch describes how the result differs from the recorded status, empty if it doesn't or if the
+// status wasn't recorded.
+func (rr *recordedRequest) mismatch(r result) string {
+	switch {
+	case rr.Status == 0 || errors.Is(r.err, context.Canceled):
+		return ""
+	case r.err != nil:
+		return fmt.Sprintf("recorded %d, got error", rr.Status)
+	case r.status != rr.Status:
+		return fmt.Sprintf("recorded %d, got %d", rr.Status, r.status)
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const recordedTraffic = `{"method": "GET", "path": "/status/200", "timestamp": "2024-05-01T10:00:00Z", "status": 200}
+{"method": "POST", "path": "/echo", "headers": {"X-Test": "1"}, "body": "hello", "timestamp": "2024-05-01T10:00:00.1Z", "status": 200}
+
+{"method": "GET", "path": "status/503", "timestamp": "2024-05-01T10:00:00.2Z", "status": 200}
+{"method": "GET", "path": "/status/404", "timestamp": "2024-05-01T10:00:00.2Z"}
+`
+
+func replayServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo" {
+			body, _ := io.ReadAll(r.Body)
+			if r.Method != http.MethodPost || r.Header.Get("X-Test") != "1" || string(body) != "hello" {
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+			return
+		}
+		status, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/status/"))
+		if err != nil {
+			status = http.StatusNotFound
+		}
+		rw.WriteHeader(status)
+	}))
+}
+
+func TestReplay(t *testing.T) {
+	server := replayServer()
+	defer server.Close()
+
+	start := time.Now()
+	stats, err := replay(context.Background(), server.Client(), server.URL, strings.NewReader(recordedTraffic), 2, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last requests were recorded 200ms after the first one, replayed twice as fast.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Replay took %s", elapsed)
+	}
+	if stats.statuses[http.StatusOK] != 2 || stats.statuses[http.StatusServiceUnavailable] != 1 || stats.statuses[http.StatusNotFound] != 1 {
+		t.Errorf("Unexpected statuses %v", stats.statuses)
+	}
+	// The request without a recorded status isn't compared.
+	if len(stats.mismatches) != 1 || stats.mismatches["recorded 200, got 503"] != 1 {
+		t.Errorf("Unexpected mismatches %v", stats.mismatches)
+	}
+
+	var out bytes.Buffer
+	stats.print(&out)
+	if !strings.Contains(out.String(), "Mismatches:\n  recorded 200, got 503") {
+		t.Errorf("Summary misses mismatches:\n%s", out.String())
+	}
+}
+
+func TestReplayAsFastAsPossible(t *testing.T) {
+	server := replayServer()
+	defer server.Close()
+
+	traffic := `{"path": "/status/200", "timestamp": "2024-05-01T10:00:00Z"}
+{"path": "/status/200", "timestamp": "2024-05-01T11:00:00Z"}`
+	stats, err := replay(context.Background(), server.Client(), server.URL, strings.NewReader(traffic), 0, 4, false)
+	if err != nil || stats.statuses[http.StatusOK] != 2 {
+		t.Errorf("Unexpected replay result %v, %v", stats.statuses, err)
+	}
+}
+
+func TestReplayConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, highest := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		mu.Lock()
+		inFlight++
+		highest = max(highest, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	traffic := strings.Repeat(`{"path": "/", "timestamp": "2024-05-01T10:00:00Z"}`+"\n", 20)
+	stats, err := replay(context.Background(), server.Client(), server.URL, strings.NewReader(traffic), 0, 3, false)
+	if err != nil || stats.statuses[http.StatusOK] != 20 {
+		t.Errorf("Unexpected replay result %v, %v", stats.statuses, err)
+	}
+	if highest > 3 {
+		t.Errorf("%d requests were in flight with a concurrency of 3", highest)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	server := replayServer()
+	defer server.Close()
+
+	traffic := `{"path": "/status/200", "timestamp": "2024-05-01T10:00:00Z", "status": 200}
+not json`
+	stats, err := replay(context.Background(), server.Client(), server.URL, strings.NewReader(traffic), 1, 4, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if stats.statuses[http.StatusOK] != 1 {
+		t.Errorf("Requests before the malformed line weren't replayed: %v", stats.statuses)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	traffic = `{"path": "/status/200", "timestamp": "2024-05-01T10:00:00Z"}
+{"path": "/status/200", "timestamp": "2024-05-01T11:00:00Z"}`
+	stats, err = replay(ctx, server.Client(), server.URL, strings.NewReader(traffic), 1, 4, false)
+	if err != nil || stats.statuses[http.StatusOK] != 1 {
+		t.Errorf("Interrupted replay gave %v, %v", stats.statuses, err)
+	}
+}
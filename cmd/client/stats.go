@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadStats collects the results of a run.
+type loadStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	elapsed   time.Duration
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+	backends  map[string]int
+	// mismatches count replayed requests by how their status differs from the recorded one.
+	mismatches map[string]int
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{
+		started:    time.Now(),
+		statuses:   make(map[int]int),
+		errors:     make(map[string]int),
+		backends:   make(map[string]int),
+		mismatches: make(map[string]int),
+	}
+}
+
+func (s *loadStats) record(r result) {
+	if errors.Is(r.err, context.Canceled) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, r.latency)
+	if r.err != nil {
+		s.errors[errorKind(r.err)]++
+		return
+	}
+	s.statuses[r.status]++
+	s.backends[r.backend]++
+}
+
+func (s *loadStats) recordMismatch(mismatch string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mismatches[mismatch]++
+}
+
+func (s *loadStats) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elapsed = time.Since(s.started)
+}
+
+// percentile returns the latency below which the share p of requests completed, using nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// print writes the summary of the run: throughput, latency percentiles, and histograms of statuses,
+// errors and backends.
+func (s *loadStats) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := len(s.latencies)
+	failed := 0
+	for _, n := range s.errors {
+		failed += n
+	}
+	rate := 0.0
+	if s.elapsed > 0 {
+		rate = float64(total) / s.elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "Requests: %d in %s (%.1f req/s), %d errors\n", total, s.elapsed.Round(time.Millisecond), rate, failed)
+	if total == 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	fmt.Fprintf(w, "Latency: mean %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		round(sum/time.Duration(total)), round(percentile(sorted, 0.5)), round(percentile(sorted, 0.9)),
+		round(percentile(sorted, 0.99)), round(sorted[total-1]))
+
+	statuses := make(map[string]int, len(s.statuses))
+	for status, n := range s.statuses {
+		statuses[fmt.Sprint(status)] = n
+	}
+	printHistogram(w, "Status", statuses, total)
+	printHistogram(w, "Errors", s.errors, total)
+	backends := make(map[string]int, len(s.backends))
+	for backend, n := range s.backends {
+		if backend == "" {
+			backend = "(unknown, run the balancer with -trace)"
+		}
+		backends[backend] += n
+	}
+	printHistogram(w, "Backends", backends, total-failed)
+	printHistogram(w, "Mismatches", s.mismatches, total)
+}
+
+func printHistogram(w io.Writer, title string, counts map[string]int, total int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%s:\n", title)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "  %s\t%d\t%.1f%%\n", key, counts[key], 100*float64(counts[key])/float64(total))
+	}
+	_ = tw.Flush()
+}
+
+// round keeps latencies readable.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newAdminHandler serves the balancer's own endpoints on a separate port, away from proxied traffic.
+func newAdminHandler(rt *router) http.Handler {
+	h := new(http.ServeMux)
+	h.HandleFunc("/metrics", metricsHandler(rt))
+	h.HandleFunc("/cache/purge", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, *adminToken) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if rt.cache == nil {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		purged := rt.cache.purge(r.URL.Query().Get("prefix"))
+		writeJSON(rw, http.StatusOK, map[string]int{"purged": purged})
+	})
+	h.HandleFunc("/register", registrationHandler(rt, registrations, *registerToken))
+	h.HandleFunc("/backends", func(rw http.ResponseWriter, r *http.Request) {
+		backends := make(map[string][]*Backend, len(rt.pools))
+		for name, p := range rt.pools {
+			backends[name] = p.backendList()
+		}
+		writeJSON(rw, http.StatusOK, backends)
+	})
+	h.HandleFunc("/backends/events", backendEventsHandler)
+	for action, state := range map[string]BackendState{
+		"drain":   StateDraining,
+		"disable": StateDisabled,
+		"enable":  StateHealthy,
+	} {
+		h.HandleFunc("/backends/"+action, backendStateHandler(rt, state, *adminToken))
+	}
+	return h
+}
+
+// authorized checks that the request carries the token as "Authorization: Bearer <token>". Without
+// a token nothing is authorized.
+func authorized(r *http.Request, token string) bool {
+	provided, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// backendStateHandler changes the state of the backend given by the pool and address query parameters.
+// Requests must carry the admin token.
+func backendStateHandler(rt *router, state BackendState, token string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p, ok := rt.pools[r.URL.Query().Get("pool")]
+		if !ok {
+			http.Error(rw, "unknown pool", http.StatusNotFound)
+			return
+		}
+		backend, err := p.setState(r.URL.Query().Get("address"), state)
+		if errors.Is(err, errUnknownBackend) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(rw, http.StatusOK, backend)
+	}
+}
+
+// backendEventsHandler streams backend events as JSON lines until the client disconnects.
+// Events are dropped for clients that don't keep up.
+func backendEventsHandler(rw http.ResponseWriter, r *http.Request) {
+	events := make(chan BackendEvent, 64)
+	unsubscribe := backendEvents.subscribe(func(event BackendEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	rc := http.NewResponseController(rw)
+	_ = rc.SetWriteDeadline(time.Time{})
+	rw.Header().Set("content-type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	encoder := json.NewEncoder(rw)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
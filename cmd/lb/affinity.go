@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAffinityCookie = "lb-affinity"
+	defaultAffinityTTL    = time.Hour
+)
+
+// affinity pins clients to a backend with a signed cookie holding the backend address and expiry time.
+type affinity struct {
+	cookieName string
+	ttl        time.Duration
+	key        []byte
+}
+
+func newAffinity(cfg *AffinityConfig) (*affinity, error) {
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("affinity signing key is empty")
+	}
+	a := &affinity{
+		cookieName: cfg.Cookie,
+		ttl:        time.Duration(cfg.TTL),
+		key:        []byte(cfg.Key),
+	}
+	if a.cookieName == "" {
+		a.cookieName = defaultAffinityCookie
+	}
+	if a.ttl <= 0 {
+		a.ttl = defaultAffinityTTL
+	}
+	return a, nil
+}
+
+func (a *affinity) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookie returns the cookie pinning the client to the server.
+func (a *affinity) cookie(server string, now time.Time, secure bool) *http.Cookie {
+	payload := server + "|" + strconv.FormatInt(now.Add(a.ttl).Unix(), 10)
+	return &http.Cookie{
+		Name:     a.cookieName,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + a.sign(payload),
+		Path:     "/",
+		MaxAge:   int(a.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// server returns the backend the request is pinned to, if it carries a valid, unexpired cookie.
+func (a *affinity) server(r *http.Request, now time.Time) (string, bool) {
+	c, err := r.Cookie(a.cookieName)
+	if err != nil {
+		return "", false
+	}
+	encoded, signature, found := strings.Cut(c.Value, ".")
+	if !found {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(a.sign(string(payload)))) {
+		return "", false
+	}
+	server, expiresString, found := strings.Cut(string(payload), "|")
+	if !found {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(expiresString, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", false
+	}
+	return server, true
+}
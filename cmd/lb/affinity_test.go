@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinityCookie(t *testing.T) {
+	a, err := newAffinity(&AffinityConfig{Key: "secret", TTL: Duration(time.Minute)})
+	require.NoError(t, err)
+	now := time.Now()
+
+	cookie := a.cookie("server2:8080", now, false)
+	assert.Equal(t, defaultAffinityCookie, cookie.Name)
+	assert.Equal(t, 60, cookie.MaxAge)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+
+	t.Run("Valid", func(t *testing.T) {
+		server, ok := a.server(req, now)
+		assert.True(t, ok)
+		assert.Equal(t, "server2:8080", server)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, ok := a.server(req, now.Add(2*time.Minute))
+		assert.False(t, ok)
+	})
+
+	t.Run("Other key", func(t *testing.T) {
+		other, err := newAffinity(&AffinityConfig{Key: "another"})
+		require.NoError(t, err)
+		other.cookieName = a.cookieName
+		_, ok := other.server(req, now)
+		assert.False(t, ok)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		forged := a.cookie("server3:8080", now, false)
+		payload, _, _ := strings.Cut(forged.Value, ".")
+		_, signature, _ := strings.Cut(cookie.Value, ".")
+		forged.Value = payload + "." + signature
+		tampered := httptest.NewRequest("GET", "/", nil)
+		tampered.AddCookie(forged)
+		_, ok := a.server(tampered, now)
+		assert.False(t, ok)
+	})
+
+	t.Run("Missing key", func(t *testing.T) {
+		_, err := newAffinity(&AffinityConfig{})
+		assert.Error(t, err)
+	})
+}
+
+func TestPoolPickForAffinity(t *testing.T) {
+	servers := []string{"sticky1:8080", "sticky2:8080"}
+	p, err := newPool("sticky", PoolConfig{
+		Servers:  servers,
+		Strategy: "round-robin",
+		Affinity: &AffinityConfig{Cookie: "backend", Key: "secret"},
+	})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.setHealthy(s, true)
+	}
+
+	rr := httptest.NewRecorder()
+	first, ok := p.pickFor(rr, httptest.NewRequest("GET", "/", nil))
+	require.True(t, ok)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "backend", cookies[0].Name)
+
+	// Round robin would choose the other server, but the cookie keeps the client where it was.
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookies[0])
+		rr := httptest.NewRecorder()
+		server, ok := p.pickFor(rr, req)
+		require.True(t, ok)
+		assert.Equal(t, first, server)
+		assert.Empty(t, rr.Result().Cookies())
+	}
+
+	// Once the pinned backend is unhealthy the strategy chooses again and the cookie is replaced.
+	p.setHealthy(first, false)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	server, ok := p.pickFor(rr, req)
+	require.True(t, ok)
+	assert.NotEqual(t, first, server)
+	require.Len(t, rr.Result().Cookies(), 1)
+	assert.NotEqual(t, cookies[0].Value, rr.Result().Cookies()[0].Value)
+	assert.Equal(t, http.SameSiteLaxMode, rr.Result().Cookies()[0].SameSite)
+}
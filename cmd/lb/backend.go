@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BackendState is a step in the lifecycle of a backend. Only healthy backends receive new requests.
+type BackendState int
+
+const (
+	// StateUnknown backends haven't been health checked yet.
+	StateUnknown BackendState = iota
+	StateHealthy
+	StateUnhealthy
+	// StateDraining backends finish their in-flight requests and become disabled.
+	StateDraining
+	// StateDisabled backends were taken out of rotation by an operator or discovery.
+	StateDisabled
+	// StateEjected backends have an open circuit breaker.
+	StateEjected
+)
+
+var backendStateNames = []string{"unknown", "healthy", "unhealthy", "draining", "disabled", "ejected"}
+
+func (s BackendState) String() string {
+	if int(s) < len(backendStateNames) {
+		return backendStateNames[s]
+	}
+	return fmt.Sprintf("BackendState(%d)", int(s))
+}
+
+func (s BackendState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *BackendState) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, known := range backendStateNames {
+		if name == known {
+			*s = BackendState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown backend state %q", name)
+}
+
+// Backend is a server of a pool in some state of its lifecycle. Backends are never modified:
+// a transition replaces the backend with a new one, so snapshots of the pool stay consistent.
+type Backend struct {
+	Address string       `json:"address"`
+	State   BackendState `json:"state"`
+	// Reason explains the last transition, Since is when it happened.
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	// Degraded healthy backends reported being degraded by their last health check and get less traffic.
+	Degraded bool `json:"degraded,omitempty"`
+
+	previous    BackendState
+	checkPassed bool
+}
+
+// transition returns the backend in a new state.
+func (b *Backend) transition(to BackendState, reason string, now time.Time) *Backend {
+	next := *b
+	next.State, next.Reason, next.Since, next.previous = to, reason, now, b.State
+	return &next
+}
+
+// sameTransition tells whether the backend is still in the state of the other one, which may be
+// an older copy. A nil backend was removed from its pool.
+func (b *Backend) sameTransition(other *Backend) bool {
+	return b != nil && b.State == other.State && b.Since.Equal(other.Since)
+}
+
+// Reasons of the events marking a backend added to or removed from its pool. Added backends start with
+// an event from unknown to unknown, and removed ones end with a transition to disabled.
+const (
+	reasonAdded   = "added"
+	reasonRemoved = "removed"
+)
+
+// BackendEvent describes a transition of a backend.
+type BackendEvent struct {
+	Pool    string       `json:"pool"`
+	Address string       `json:"address"`
+	From    BackendState `json:"from"`
+	To      BackendState `json:"to"`
+	Reason  string       `json:"reason"`
+	Time    time.Time    `json:"time"`
+}
+
+// eventBus delivers backend events to subscribers. Handlers are called synchronously in the order
+// of transitions while the pool is locked, so they must be quick and must not call back into the pool.
+type eventBus struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]func(BackendEvent)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{handlers: make(map[int]func(BackendEvent))}
+}
+
+// backendEvents carries the lifecycle events of backends of all pools.
+var backendEvents = newEventBus()
+
+// subscribe registers the handler and returns a function cancelling the subscription.
+func (eb *eventBus) subscribe(handler func(BackendEvent)) func() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	id := eb.next
+	eb.next++
+	eb.handlers[id] = handler
+	return func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		delete(eb.handlers, id)
+	}
+}
+
+func (eb *eventBus) publish(event BackendEvent) {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	for _, handler := range eb.handlers {
+		handler(event)
+	}
+}
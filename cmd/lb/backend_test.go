@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectEvents records the events of one pool.
+func collectEvents(t *testing.T, pool string) func() []BackendEvent {
+	var (
+		mu     sync.Mutex
+		events []BackendEvent
+	)
+	t.Cleanup(backendEvents.subscribe(func(event BackendEvent) {
+		if event.Pool == pool {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}))
+	return func() []BackendEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]BackendEvent(nil), events...)
+	}
+}
+
+func transitions(events []BackendEvent) []string {
+	var result []string
+	for _, e := range events {
+		result = append(result, e.From.String()+"->"+e.To.String()+": "+e.Reason)
+	}
+	return result
+}
+
+func TestBackendLifecycle(t *testing.T) {
+	events := collectEvents(t, "lifecycle")
+	p, err := newPool("lifecycle", PoolConfig{Servers: []string{"life1:8080", "life2:8080"}})
+	require.NoError(t, err)
+
+	p.setHealthy("life1:8080", true)
+	p.setHealthy("life2:8080", true)
+	p.setHealthy("life1:8080", false)
+	p.setHealthy("life1:8080", false)
+	p.setHealthy("life1:8080", true)
+	assert.Equal(t, []string{
+		"unknown->unknown: added",
+		"unknown->unknown: added",
+		"unknown->healthy: health check passed",
+		"unknown->healthy: health check passed",
+		"healthy->unhealthy: health check failed",
+		"unhealthy->healthy: health check passed",
+	}, transitions(events()))
+
+	backend, err := p.setState("life1:8080", StateDisabled)
+	require.NoError(t, err)
+	assert.Equal(t, StateDisabled, backend.State)
+	assert.Equal(t, "disabled by operator", backend.Reason)
+	// Health checks don't bring disabled backends back.
+	p.setHealthy("life1:8080", true)
+	assert.False(t, p.isHealthy("life1:8080"))
+	for i := 0; i < 5; i++ {
+		server, ok := p.pick("/" + string(rune('a'+i)))
+		require.True(t, ok)
+		assert.Equal(t, "life2:8080", server)
+	}
+
+	p.setHealthy("life1:8080", false)
+	backend, err = p.setState("life1:8080", StateHealthy)
+	require.NoError(t, err)
+	assert.Equal(t, StateUnhealthy, backend.State, "enabled backends keep failing their checks")
+
+	_, err = p.setState("life1:8080", StateHealthy)
+	assert.Error(t, err)
+	_, err = p.setState("unknown:8080", StateDisabled)
+	assert.ErrorIs(t, err, errUnknownBackend)
+
+	assert.Equal(t, []*Backend{p.state.Load().backends["life1:8080"], p.state.Load().backends["life2:8080"]}, p.backendList())
+}
+
+func TestBackendDraining(t *testing.T) {
+	events := collectEvents(t, "draining")
+	p, err := newPool("draining", PoolConfig{Servers: []string{"drain1:8080", "drain2:8080"}})
+	require.NoError(t, err)
+	p.setHealthy("drain1:8080", true)
+	p.setHealthy("drain2:8080", true)
+
+	done := trackInFlight("drain1:8080")
+	backend, err := p.setState("drain1:8080", StateDraining)
+	require.NoError(t, err)
+	assert.Equal(t, StateDraining, backend.State)
+	assert.Equal(t, []string{"drain2:8080"}, p.state.Load().healthy)
+
+	time.Sleep(2 * drainPollInterval)
+	assert.Equal(t, StateDraining, p.backendList()[0].State, "requests in flight are let to finish")
+	done()
+	assert.Eventually(t, func() bool { return p.backendList()[0].State == StateDisabled }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "draining->disabled: drained", transitions(events())[len(events())-1])
+
+	_, err = p.setState("drain1:8080", StateDraining)
+	assert.Error(t, err)
+}
+
+func TestBackendEjection(t *testing.T) {
+	events := collectEvents(t, "ejection")
+	servers := []string{"eject1:8080", "eject2:8080"}
+	p, err := newPool("ejection", PoolConfig{
+		Servers:        servers,
+		CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1, OpenTimeout: Duration(50 * time.Millisecond)},
+	})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.setHealthy(s, true)
+	}
+
+	p.report("eject1:8080", false, 0)
+	assert.Equal(t, StateEjected, p.backendList()[0].State)
+	assert.Equal(t, []string{"eject2:8080"}, p.state.Load().healthy)
+	// Passing health checks doesn't override the breaker.
+	p.setHealthy("eject1:8080", true)
+	assert.False(t, p.isHealthy("eject1:8080"))
+
+	assert.Eventually(t, func() bool { return p.isHealthy("eject1:8080") }, time.Second, 10*time.Millisecond)
+	got := transitions(events())
+	assert.Equal(t, []string{
+		"healthy->ejected: circuit breaker opened",
+		"ejected->healthy: circuit breaker half-open",
+	}, got[len(got)-2:])
+}
+
+func TestBackendStateJSON(t *testing.T) {
+	data, err := json.Marshal(&Backend{Address: "a:80", State: StateEjected, Reason: "circuit breaker opened"})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"state":"ejected"`)
+	assert.Equal(t, "BackendState(42)", BackendState(42).String())
+}
+
+func TestBackendMetrics(t *testing.T) {
+	recordBackendEvent(BackendEvent{Pool: "metrics", Address: "m:80", Reason: reasonAdded})
+	recordBackendEvent(BackendEvent{Pool: "metrics", Address: "m:80", To: StateHealthy, Reason: "health check passed"})
+	assert.Equal(t, BackendMetrics{State: StateHealthy, Reason: "health check passed", Transitions: 1},
+		collectMetrics(nil).Backends["metrics"]["m:80"])
+
+	recordBackendEvent(BackendEvent{Pool: "metrics", Address: "m:80", From: StateHealthy, To: StateDisabled, Reason: reasonRemoved})
+	assert.NotContains(t, collectMetrics(nil).Backends["metrics"], "m:80")
+}
+
+func TestBackendRequestMetrics(t *testing.T) {
+	t.Cleanup(backendEvents.subscribe(recordBackendEvent))
+	p, err := newPool("requests", PoolConfig{Servers: []string{"req:8080"}})
+	require.NoError(t, err)
+	pools := map[string]*pool{"requests": p}
+	assert.Equal(t, BackendMetrics{Reason: reasonAdded}, collectMetrics(pools).Backends["requests"]["req:8080"])
+
+	for i := 1; i <= 100; i++ {
+		p.report("req:8080", i%10 != 0, time.Duration(i)*time.Millisecond)
+	}
+	p.report("req:8080", true, 0)
+	metrics := collectMetrics(pools).Backends["requests"]["req:8080"]
+	assert.Equal(t, int64(101), metrics.Requests)
+	assert.Equal(t, int64(10), metrics.Errors)
+	assert.Equal(t, Duration(50*time.Millisecond), metrics.LatencyP50)
+	assert.Equal(t, Duration(99*time.Millisecond), metrics.LatencyP99)
+}
+
+func TestBackendsAdminAPI(t *testing.T) {
+	rt, err := newRouter(&Config{
+		Pools:  map[string]PoolConfig{"admin": {Servers: []string{"adm1:8080", "adm2:8080"}}},
+		Routes: []RouteConfig{{Pool: "admin"}},
+	})
+	require.NoError(t, err)
+	p := rt.pools["admin"]
+	p.setHealthy("adm1:8080", true)
+	p.setHealthy("adm2:8080", false)
+
+	*adminToken = "secret"
+	defer func() { *adminToken = "" }()
+	admin := httptest.NewServer(newAdminHandler(rt))
+	defer admin.Close()
+	post := func(url, token string) *http.Response {
+		req, err := http.NewRequest("POST", admin.URL+url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp, err := http.Get(admin.URL + "/backends")
+	require.NoError(t, err)
+	var backends map[string][]Backend
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&backends))
+	resp.Body.Close()
+	require.Len(t, backends["admin"], 2)
+	assert.Equal(t, StateHealthy, backends["admin"][0].State)
+	assert.Equal(t, StateUnhealthy, backends["admin"][1].State)
+
+	stream, err := http.Get(admin.URL + "/backends/events")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		resp = post("/backends/disable?pool=admin&address=adm1:8080", token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.True(t, p.isHealthy("adm1:8080"))
+
+	resp = post("/backends/disable?pool=admin&address=adm1:8080", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var event BackendEvent
+	require.NoError(t, json.NewDecoder(bufio.NewReader(stream.Body)).Decode(&event))
+	assert.Equal(t, BackendEvent{
+		Pool: "admin", Address: "adm1:8080", From: StateHealthy, To: StateDisabled,
+		Reason: "disabled by operator", Time: event.Time,
+	}, event)
+
+	for url, status := range map[string]int{
+		"/backends/enable?pool=admin&address=adm2:8080": http.StatusConflict,
+		"/backends/drain?pool=admin&address=nope:8080":  http.StatusNotFound,
+		"/backends/drain?pool=nope&address=adm1:8080":   http.StatusNotFound,
+	} {
+		assert.Equal(t, status, post(url, "secret").StatusCode, url)
+	}
+}
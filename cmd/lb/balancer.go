@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/roman-mazur/design-practice-2-template/httptools"
@@ -16,8 +21,23 @@ import (
 
 var (
 	port = flag.Int("port", 8090, "load balancer port")
+	adminPort = flag.Int("admin-port", 8091, "port of the admin API serving metrics")
 	timeoutSec = flag.Int("timeout-sec", 1, "request timeout time in seconds")
 	https = flag.Bool("https", false, "whether backends support HTTPs")
+	upstreamH2C = flag.Bool("upstream-h2c", false, "whether to talk to backends over cleartext HTTP/2")
+
+	tlsCert = flag.String("tls-cert", "", "certificate file for serving HTTPS and HTTP/2")
+	tlsKey = flag.String("tls-key", "", "private key file for serving HTTPS and HTTP/2")
+	h2c = flag.Bool("h2c", false, "whether to accept cleartext HTTP/2 from clients")
+
+	mode = flag.String("mode", "http", "balancing mode: http, tcp or udp")
+	modePool = flag.String("pool", defaultPoolName, "pool balanced in the tcp and udp modes")
+	idleTimeout = flag.Duration("idle-timeout", 5*time.Minute, "how long a tcp connection may stay idle")
+	sessionTimeout = flag.Duration("session-timeout", 30*time.Second, "how long a udp client stays bound to its backend without traffic")
+	registerToken = flag.String("register-token", os.Getenv("LB_REGISTER_TOKEN"), "shared token servers use to register themselves")
+	adminToken = flag.String("admin-token", os.Getenv("LB_ADMIN_TOKEN"), "token operators use to change backend states through the admin API")
+	registerTTL = flag.Duration("register-ttl", defaultRegistrationTTL, "how long a server registration lasts without heartbeats")
+	configPath = flag.String("config", "", "path to the JSON file with backend pools and routing rules")
 
 	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
 )
@@ -29,10 +49,61 @@ var (
 		"server2:8080",
 		"server3:8080",
 	}
-	healthyServersMutex sync.Mutex
-	healthyServers []string
+
+	// inFlight counts requests and tunnelled connections currently served by each backend. The map is
+	// replaced rather than modified when pools add or remove backends, so requests read it without locking.
+	inFlight      atomic.Pointer[map[string]*atomic.Int64]
+	inFlightPools = make(map[string]int)
+	inFlightMutex sync.Mutex
 )
 
+// registerInFlight creates the in-flight counter of a backend unless another pool already did.
+func registerInFlight(server string) {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	inFlightPools[server]++
+	counters := inFlightCounters()
+	if _, ok := counters[server]; ok {
+		return
+	}
+	updated := make(map[string]*atomic.Int64, len(counters)+1)
+	for s, counter := range counters {
+		updated[s] = counter
+	}
+	updated[server] = new(atomic.Int64)
+	inFlight.Store(&updated)
+}
+
+// releaseInFlight removes the in-flight counter of a backend once no pool has it any more.
+func releaseInFlight(server string) {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	if inFlightPools[server]--; inFlightPools[server] > 0 {
+		return
+	}
+	delete(inFlightPools, server)
+	counters := inFlightCounters()
+	updated := make(map[string]*atomic.Int64, len(counters))
+	for s, counter := range counters {
+		if s != server {
+			updated[s] = counter
+		}
+	}
+	inFlight.Store(&updated)
+}
+
+func inFlightCounters() map[string]*atomic.Int64 {
+	if counters := inFlight.Load(); counters != nil {
+		return *counters
+	}
+	return nil
+}
+
+func inFlightCounter(server string) (*atomic.Int64, bool) {
+	counter, ok := inFlightCounters()[server]
+	return counter, ok
+}
+
 func scheme() string {
 	if *https {
 		return "https"
@@ -46,116 +117,209 @@ type HttpClient interface {
 
 var client HttpClient = http.DefaultClient
 
-func health(dst string, client HttpClient) bool {
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	req, _ := http.NewRequestWithContext(ctx, "GET",
-		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false
+// upstreamTransport returns the transport used to reach backends: HTTP/1.1 with HTTP/2 negotiated
+// over TLS, or HTTP/2 only when -upstream-h2c is set, in cleartext or over TLS with -https.
+func upstreamTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	if *upstreamH2C {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP1(true)
+	}
+	transport.Protocols = protocols
+	return transport
+}
+
+// Hop-by-hop headers are meaningful only for a single connection and must not be proxied.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
 	}
-	if resp.StatusCode != http.StatusOK {
-		return false
+	for _, name := range hopHeaders {
+		h.Del(name)
 	}
-	return true
 }
 
 func forward(dst string, rw http.ResponseWriter, r *http.Request, client HttpClient) error {
-	ctx, _ := context.WithTimeout(r.Context(), timeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	resp, err := roundTrip(ctx, dst, r, client)
+	if err == nil {
+		writeResponse(dst, rw, resp)
+		return nil
+	} else if maxBytesErr := new(http.MaxBytesError); errors.As(err, &maxBytesErr) {
+		log.Printf("Request body exceeds %d bytes", maxBytesErr.Limit)
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		return err
+	} else {
+		log.Printf("Failed to get response from %s: %s", dst, err)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return err
+	}
+}
+
+// roundTrip sends a copy of the client request to the backend.
+func roundTrip(ctx context.Context, dst string, r *http.Request, client HttpClient) (*http.Response, error) {
 	fwdRequest := r.Clone(ctx)
 	fwdRequest.RequestURI = ""
 	fwdRequest.URL.Host = dst
 	fwdRequest.URL.Scheme = scheme()
 	fwdRequest.Host = dst
+	te := fwdRequest.Header.Values("Te")
+	removeHopHeaders(fwdRequest.Header)
+	// gRPC and other trailer-aware clients must keep announcing that they accept trailers.
+	if headerHasToken(http.Header{"Te": te}, "Te", "trailers") {
+		fwdRequest.Header.Set("Te", "trailers")
+	}
+	return client.Do(fwdRequest)
+}
 
-	resp, err := client.Do(fwdRequest)
-	if err == nil {
-		for k, values := range resp.Header {
-			for _, value := range values {
-				rw.Header().Add(k, value)
+// writeResponse passes the backend response to the client and closes its body.
+func writeResponse(dst string, rw http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for k, values := range resp.Header {
+		for _, value := range values {
+			rw.Header().Add(k, value)
+		}
+	}
+	if *traceEnabled {
+		rw.Header().Set("lb-from", dst)
+	}
+	log.Println("fwd", resp.StatusCode, resp.Request.URL)
+	rw.WriteHeader(resp.StatusCode)
+	// Bodies of unknown length may be streamed (e.g. over HTTP/2), so pass every chunk on immediately.
+	err := copyBody(rw, resp.Body, resp.ContentLength == -1)
+	if err != nil {
+		log.Printf("Failed to write response: %s", err)
+	}
+	// Trailers are only known once the body has been read.
+	for k, values := range resp.Trailer {
+		for _, value := range values {
+			rw.Header().Add(http.TrailerPrefix+k, value)
+		}
+	}
+}
+
+func copyBody(rw http.ResponseWriter, body io.Reader, flush bool) error {
+	if !flush {
+		_, err := io.Copy(rw, body)
+		return err
+	}
+	rc := http.NewResponseController(rw)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := rw.Write(buf[:n]); err != nil {
+				return err
 			}
+			_ = rc.Flush()
 		}
-		if *traceEnabled {
-			rw.Header().Set("lb-from", dst)
+		if err == io.EOF {
+			return nil
 		}
-		log.Println("fwd", resp.StatusCode, resp.Request.URL)
-		rw.WriteHeader(resp.StatusCode)
-		defer resp.Body.Close()
-		_, err := io.Copy(rw, resp.Body)
 		if err != nil {
-			log.Printf("Failed to write response: %s", err)
+			return err
 		}
-		return nil
-	} else {
-		log.Printf("Failed to get response from %s: %s", dst, err)
-		rw.WriteHeader(http.StatusServiceUnavailable)
-		return err
 	}
 }
 
 func main() {
 	flag.Parse()
+	client = &http.Client{Transport: upstreamTransport()}
+	healthClient = &http.Client{Transport: upstreamTransport()}
 
-	// TODO: Використовуйте дані про стан сервреа, щоб підтримувати список тих серверів, яким можна відправляти ззапит.
-	for _, server := range serversPool {
-		server := server
+	timeout = time.Duration(*timeoutSec) * time.Second
+	registrations.ttl = *registerTTL
 
-		checkServerHealth(server)
-		go func() {
-			for range time.Tick(10 * time.Second) {
-				checkServerHealth(server)
-			}
-		}()
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
+	backendEvents.subscribe(recordBackendEvent)
+	backendEvents.subscribe(func(event BackendEvent) {
+		log.Printf("[%s] %s: %s -> %s (%s)", event.Pool, event.Address, event.From, event.To, event.Reason)
+	})
+	rt, err := newRouter(cfg)
+	if err != nil {
+		log.Fatalf("Invalid config: %s", err)
+	}
+	for _, p := range rt.pools {
+		p.startDiscovery()
+		p.startHealthChecks()
 	}
-
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		// TODO: Рееалізуйте свій алгоритм балансувальника.
-		healthyServersMutex.Lock()
-		defer healthyServersMutex.Unlock()
-
-		// Якщо немає доступних здорових серверів, повертаємо статус "Service Unavailable"
-		if len(healthyServers) == 0 {
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			return
-		}
-
-		pathHash := hash(r.URL.Path)
-		serverIndex := int(pathHash) % len(healthyServers)
-		forward(healthyServers[serverIndex], rw, r, client)
-	}))
 
 	log.Println("Starting load balancer...")
-	log.Printf("Tracing support enabled: %t", *traceEnabled)
-	frontend.Start()
+	switch *mode {
+	case "http":
+		frontend := httptools.CreateServerWithOptions(*port, rt,
+			httptools.Options{CertFile: *tlsCert, KeyFile: *tlsKey, H2C: *h2c})
+		log.Printf("Tracing support enabled: %t", *traceEnabled)
+		log.Printf("HTTP/2 support: TLS %t, h2c %t, upstream h2c %t", *tlsCert != "", *h2c, *upstreamH2C)
+		frontend.Start()
+	case "tcp":
+		p := modePoolOrExit(rt)
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			log.Fatalf("Failed to listen: %s", err)
+		}
+		log.Printf("Balancing TCP connections between %v", p.serverList())
+		go func() {
+			err := (&tcpProxy{pool: p, idleTimeout: *idleTimeout}).serve(listener)
+			log.Fatalf("TCP listener finished: %s. Finishing the process.", err)
+		}()
+	case "udp":
+		p := modePoolOrExit(rt)
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: *port})
+		if err != nil {
+			log.Fatalf("Failed to listen: %s", err)
+		}
+		log.Printf("Balancing UDP sessions between %v", p.serverList())
+		go func() {
+			err := newUDPProxy(p, *sessionTimeout).serve(conn)
+			log.Fatalf("UDP listener finished: %s. Finishing the process.", err)
+		}()
+	default:
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+	httptools.CreateServer(*adminPort, newAdminHandler(rt)).Start()
 	signal.WaitForTerminationSignal()
 }
 
-// Function to check server availability
-func checkServerHealth(server string) {
-	isHealthy := health(server, client)
-	log.Printf("\x1b[35m%s %t\x1b[0m", server, isHealthy)
-
-	healthyServersMutex.Lock()
-	defer healthyServersMutex.Unlock()
-
-	index := -1
-	for i, v := range healthyServers {
-		if v == server {
-			index = i
-			break
-		}
+func modePoolOrExit(rt *router) *pool {
+	p, ok := rt.pools[*modePool]
+	if !ok {
+		log.Fatalf("Unknown pool %q", *modePool)
 	}
+	return p
+}
 
-	if isHealthy {
-		if index == -1 {
-			healthyServers = append(healthyServers, server)
-		}
-	} else {
-		if index != -1 {
-			healthyServers = append(healthyServers[:index], healthyServers[index+1:]...)
-		}
+// trackInFlight increments the in-flight counter of the backend and returns a function undoing it.
+func trackInFlight(dst string) func() {
+	counter, ok := inFlightCounter(dst)
+	if !ok {
+		return func() {}
 	}
-	fmt.Println(healthyServers)
+	counter.Add(1)
+	return func() { counter.Add(-1) }
 }
 
 // djb2 hash algorithm
@@ -62,14 +62,12 @@ func TestHealth(t *testing.T) {
 	httpmock.RegisterResponder("GET", fmt.Sprintf("http://%s/health", dst),
 		httpmock.NewStringResponder(200, ""))
 
-	client := &MockHttpClient{}
-
-	assert.True(t, health(dst, client))
+	assert.NoError(t, checkWith(t, HealthCheckConfig{}, dst))
 
 	httpmock.RegisterResponder("GET", fmt.Sprintf("http://%s/health", dst),
 		httpmock.NewStringResponder(500, ""))
 
-	assert.False(t, health(dst, client))
+	assert.Error(t, checkWith(t, HealthCheckConfig{}, dst))
 }
 
 func TestHealth_Non200Status(t *testing.T) {
@@ -82,14 +80,12 @@ func TestHealth_Non200Status(t *testing.T) {
 	httpmock.RegisterResponder("GET", fmt.Sprintf("http://%s/health", dst),
 		httpmock.NewStringResponder(503, ""))
 
-	client := &MockHttpClient{}
-
-	assert.False(t, health(dst, client))
+	assert.Error(t, checkWith(t, HealthCheckConfig{}, dst))
 
 	httpmock.RegisterResponder("GET", fmt.Sprintf("http://%s/health", dst),
 		httpmock.NewStringResponder(404, ""))
 
-	assert.False(t, health(dst, client))
+	assert.Error(t, checkWith(t, HealthCheckConfig{}, dst))
 }
 
 func TestHealth_RequestError(t *testing.T) {
@@ -106,9 +102,7 @@ func TestHealth_RequestError(t *testing.T) {
 			return nil, expectedErr
 		})
 
-	client := &MockHttpClient{}
-
-	assert.False(t, health(dst, client))
+	assert.Error(t, checkWith(t, HealthCheckConfig{}, dst))
 }
 
 func TestHealth_RequestTimeout(t *testing.T) {
@@ -125,9 +119,7 @@ func TestHealth_RequestTimeout(t *testing.T) {
 			return nil, context.DeadlineExceeded
 		})
 
-	client := &MockHttpClient{}
-
-	assert.False(t, health(dst, client))
+	assert.Error(t, checkWith(t, HealthCheckConfig{}, dst))
 }
 
 func TestForwardSuccess(t *testing.T) {
@@ -190,3 +182,92 @@ func TestForwardTimeout(t *testing.T) {
 	require.Error(t, err)
 	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
 }
+
+func TestForwardTrailers(t *testing.T) {
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+	mockClient := &MockHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Body:          io.NopCloser(strings.NewReader("streamed")),
+				Header:        http.Header{"Connection": {"close"}},
+				Trailer:       http.Header{"Grpc-Status": {"0"}},
+				ContentLength: -1,
+				Request:       req,
+			}, nil
+		},
+	}
+
+	err := forward("localhost:8080", rr, req, mockClient)
+
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", rr.Body.String())
+	assert.True(t, rr.Flushed)
+	assert.Empty(t, rr.Header().Get("Connection"))
+	assert.Equal(t, "0", rr.Result().Trailer.Get("Grpc-Status"))
+}
+
+func TestForwardHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Trailer", "X-Checksum")
+		_, _ = rw.Write([]byte(r.Proto))
+		rw.Header().Set("X-Checksum", "42")
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		backend := httptest.NewUnstartedServer(handler)
+		backend.EnableHTTP2 = true
+		backend.StartTLS()
+		defer backend.Close()
+
+		*https = true
+		defer func() { *https = false }()
+
+		rr := httptest.NewRecorder()
+		err := forward(strings.TrimPrefix(backend.URL, "https://"), rr, httptest.NewRequest("GET", "/", nil), backend.Client())
+
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0", rr.Body.String())
+		assert.Equal(t, "42", rr.Result().Trailer.Get("X-Checksum"))
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		backend := httptest.NewUnstartedServer(handler)
+		backend.Config.Protocols = new(http.Protocols)
+		backend.Config.Protocols.SetUnencryptedHTTP2(true)
+		backend.Start()
+		defer backend.Close()
+
+		*https = false
+		*upstreamH2C = true
+		defer func() { *upstreamH2C = false }()
+
+		rr := httptest.NewRecorder()
+		upstream := &http.Client{Transport: upstreamTransport()}
+		err := forward(strings.TrimPrefix(backend.URL, "http://"), rr, httptest.NewRequest("GET", "/", nil), upstream)
+
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0", rr.Body.String())
+		assert.Equal(t, "42", rr.Result().Trailer.Get("X-Checksum"))
+	})
+
+	t.Run("h2c with TLS backends", func(t *testing.T) {
+		backend := httptest.NewUnstartedServer(handler)
+		backend.EnableHTTP2 = true
+		backend.StartTLS()
+		defer backend.Close()
+
+		*https = true
+		*upstreamH2C = true
+		defer func() { *https, *upstreamH2C = false, false }()
+
+		transport := upstreamTransport()
+		transport.TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+		rr := httptest.NewRecorder()
+		err := forward(strings.TrimPrefix(backend.URL, "https://"), rr, httptest.NewRequest("GET", "/", nil), &http.Client{Transport: transport})
+
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0", rr.Body.String())
+	})
+}
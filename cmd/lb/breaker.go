@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerErrorRate        = 0.5
+	defaultBreakerMinRequests      = 10
+	defaultBreakerWindow           = 10 * time.Second
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenRequests = 1
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// circuitBreaker stops sending requests to a backend after too many of them failed or were too slow.
+// After openTimeout it lets a limited number of probe requests through and closes again once they succeed.
+type circuitBreaker struct {
+	errorRate        float64
+	minRequests      int
+	window           time.Duration
+	slowThreshold    time.Duration
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	mu          sync.Mutex
+	state       breakerState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	probes      int
+	successes   int
+}
+
+func newCircuitBreaker(cfg *CircuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{
+		errorRate:        cfg.ErrorRate,
+		minRequests:      cfg.MinRequests,
+		window:           time.Duration(cfg.Window),
+		slowThreshold:    time.Duration(cfg.SlowThreshold),
+		openTimeout:      time.Duration(cfg.OpenTimeout),
+		halfOpenRequests: cfg.HalfOpenRequests,
+	}
+	if b.errorRate <= 0 || b.errorRate > 1 {
+		b.errorRate = defaultBreakerErrorRate
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = defaultBreakerMinRequests
+	}
+	if b.window <= 0 {
+		b.window = defaultBreakerWindow
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = defaultBreakerOpenTimeout
+	}
+	if b.halfOpenRequests <= 0 {
+		b.halfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+	return b
+}
+
+// acquire reports whether a request may be sent to the backend now, reserving a probe slot when half-open.
+func (b *circuitBreaker) acquire(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probes, b.successes = 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.halfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// release gives back the probe slot of a request cancelled before its outcome said anything about the
+// backend, without counting it either way.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// record accounts for the outcome of a request acquired earlier and reports whether it opened the breaker.
+// Slow responses count as failures.
+func (b *circuitBreaker) record(success bool, latency time.Duration, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.slowThreshold > 0 && latency > b.slowThreshold {
+		success = false
+	}
+
+	switch b.state {
+	case breakerClosed:
+		if now.Sub(b.windowStart) > b.window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.errorRate {
+			b.open(now)
+			return true
+		}
+	case breakerHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if !success {
+			b.open(now)
+			return true
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.state = breakerClosed
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+	}
+	return false
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+}
+
+func (b *circuitBreaker) currentState() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{
+		ErrorRate:        0.5,
+		MinRequests:      4,
+		Window:           Duration(time.Minute),
+		SlowThreshold:    Duration(time.Second),
+		OpenTimeout:      Duration(10 * time.Second),
+		HalfOpenRequests: 2,
+	})
+	now := time.Now()
+
+	t.Run("Opens on errors and slow responses", func(t *testing.T) {
+		for _, outcome := range []struct {
+			success bool
+			latency time.Duration
+		}{{true, 0}, {false, 0}, {true, 0}, {true, 2 * time.Second}} {
+			require.True(t, b.acquire(now))
+			b.record(outcome.success, outcome.latency, now)
+		}
+		assert.Equal(t, breakerOpen, b.currentState())
+		assert.False(t, b.acquire(now.Add(5*time.Second)))
+	})
+
+	now = now.Add(10 * time.Second)
+
+	t.Run("Half-open lets limited probes through", func(t *testing.T) {
+		assert.True(t, b.acquire(now))
+		assert.Equal(t, breakerHalfOpen, b.currentState())
+		assert.True(t, b.acquire(now))
+		assert.False(t, b.acquire(now))
+	})
+
+	t.Run("Failed probe opens again", func(t *testing.T) {
+		b.record(false, 0, now)
+		assert.Equal(t, breakerOpen, b.currentState())
+		assert.False(t, b.acquire(now.Add(time.Second)))
+	})
+
+	now = now.Add(10 * time.Second)
+
+	t.Run("Cancelled probe frees its slot", func(t *testing.T) {
+		require.True(t, b.acquire(now))
+		require.True(t, b.acquire(now))
+		require.False(t, b.acquire(now))
+		b.release()
+		assert.Equal(t, breakerHalfOpen, b.currentState())
+		assert.True(t, b.acquire(now))
+	})
+
+	t.Run("Successful probes close", func(t *testing.T) {
+		b.record(true, 0, now)
+		assert.Equal(t, breakerHalfOpen, b.currentState())
+		b.record(true, 0, now)
+		assert.Equal(t, breakerClosed, b.currentState())
+		assert.True(t, b.acquire(now))
+	})
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{MinRequests: 2, Window: Duration(time.Second)})
+	now := time.Now()
+
+	b.record(false, 0, now)
+	b.record(false, 0, now.Add(2*time.Second))
+	assert.Equal(t, breakerClosed, b.currentState(), "failures from an old window are forgotten")
+	b.record(false, 0, now.Add(2500*time.Millisecond))
+	assert.Equal(t, breakerOpen, b.currentState())
+}
+
+func TestPoolSkipsOpenBreakers(t *testing.T) {
+	servers := []string{"cb1:8080", "cb2:8080"}
+	p, err := newPool("breakers", PoolConfig{
+		Servers:        servers,
+		CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1},
+	})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.setHealthy(s, true)
+	}
+
+	first, ok := p.pick("/path")
+	require.True(t, ok)
+	p.report(first, false, 0)
+
+	for i := 0; i < 3; i++ {
+		server, ok := p.pick("/path")
+		require.True(t, ok)
+		assert.NotEqual(t, first, server)
+	}
+
+	second, _ := p.pick("/path")
+	p.report(second, false, 0)
+	// Both breakers are open now.
+	_, ok = p.pick("/path")
+	assert.False(t, ok)
+}
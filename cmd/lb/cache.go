@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMaxBytes      = 64 << 20
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// cacheEntry is a stored response for one variant of a URL.
+type cacheEntry struct {
+	key       string
+	path      string
+	vary      map[string]string
+	status    int
+	header    http.Header
+	body      []byte
+	etag      string
+	storedAt  time.Time
+	expiresAt time.Time
+	size      int
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.expiresAt)
+}
+
+// matches checks that the request has the same values of headers listed in Vary as the stored one.
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// responseCache is an in-memory HTTP cache for GET requests bounded by the total size of stored responses.
+// The least recently used entries are evicted first.
+type responseCache struct {
+	maxBytes      int
+	maxEntryBytes int
+
+	mu      sync.Mutex
+	entries map[string][]*list.Element
+	lru     *list.List
+	size    int
+	now     func() time.Time
+}
+
+func newResponseCache(cfg *CacheConfig) *responseCache {
+	c := &responseCache{
+		maxBytes:      cfg.MaxBytes,
+		maxEntryBytes: cfg.MaxEntryBytes,
+		entries:       make(map[string][]*list.Element),
+		lru:           list.New(),
+		now:           time.Now,
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultCacheMaxBytes
+	}
+	if c.maxEntryBytes <= 0 || c.maxEntryBytes > c.maxBytes {
+		c.maxEntryBytes = min(defaultCacheMaxEntryBytes, c.maxBytes)
+	}
+	return c
+}
+
+func cacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+func (c *responseCache) lookup(r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, element := range c.entries[cacheKey(r)] {
+		entry := element.Value.(*cacheEntry)
+		if entry.matches(r) {
+			c.lru.MoveToFront(element)
+			return entry
+		}
+	}
+	return nil
+}
+
+func (c *responseCache) store(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A new response replaces the stored one for the same variant.
+	for _, element := range c.entries[entry.key] {
+		existing := element.Value.(*cacheEntry)
+		if equalVary(existing.vary, entry.vary) {
+			c.remove(element)
+			break
+		}
+	}
+	c.entries[entry.key] = append(c.entries[entry.key], c.lru.PushFront(entry))
+	c.size += entry.size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func equalVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
+
+// remove must be called with the lock held.
+func (c *responseCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	c.size -= entry.size
+	variants := c.entries[entry.key]
+	for i, e := range variants {
+		if e == element {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.entries, entry.key)
+	} else {
+		c.entries[entry.key] = variants
+	}
+}
+
+// purge removes all responses for paths starting with the prefix and returns how many there were.
+func (c *responseCache) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if strings.HasPrefix(element.Value.(*cacheEntry).path, prefix) {
+			c.remove(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+// serve answers the request from the cache when possible and calls next otherwise,
+// storing cacheable responses and revalidating stale ones with their ETag.
+func (c *responseCache) serve(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	requestDirectives := parseCacheControl(r.Header)
+	if _, noStore := requestDirectives["no-store"]; noStore || r.Method != http.MethodGet {
+		next(rw, r)
+		return
+	}
+	_, noCache := requestDirectives["no-cache"]
+	noCache = noCache || requestDirectives["max-age"] == "0" || r.Header.Get("Pragma") == "no-cache"
+
+	now := c.now()
+	entry := c.lookup(r)
+	if entry != nil && entry.fresh(now) && !noCache {
+		c.write(rw, r, entry, "hit")
+		return
+	}
+
+	if entry != nil && entry.etag != "" {
+		conditional := r.Clone(r.Context())
+		conditional.Header.Set("If-None-Match", entry.etag)
+		conditional.Header.Del("If-Modified-Since")
+		capture := newRevalidationWriter(rw, c.maxEntryBytes)
+		next(capture, conditional)
+
+		if capture.status == http.StatusNotModified {
+			refreshed := *entry
+			refreshed.header = entry.header.Clone()
+			for _, name := range []string{"Cache-Control", "Expires", "Date", "Etag", "Vary"} {
+				if values := capture.header.Values(name); len(values) > 0 {
+					refreshed.header[name] = values
+				}
+			}
+			refreshed.storedAt = now
+			refreshed.expiresAt = now.Add(freshnessLifetime(refreshed.header, now))
+			c.store(&refreshed)
+			c.write(rw, r, &refreshed, "revalidated")
+			return
+		}
+		c.storeResponse(r, capture, now)
+		return
+	}
+
+	if *traceEnabled {
+		rw.Header().Set("lb-cache", "miss")
+	}
+	capture := newCaptureWriter(rw, c.maxEntryBytes)
+	next(capture, r)
+	c.storeResponse(r, capture, now)
+}
+
+// write sends a stored response, or 304 if the client already has it.
+func (c *responseCache) write(rw http.ResponseWriter, r *http.Request, entry *cacheEntry, outcome string) {
+	for k, values := range entry.header {
+		rw.Header()[k] = append([]string(nil), values...)
+	}
+	rw.Header().Set("Age", strconv.Itoa(int(c.now().Sub(entry.storedAt).Seconds())))
+	if *traceEnabled {
+		rw.Header().Set("lb-cache", outcome)
+	}
+	if entry.etag != "" && etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.WriteHeader(entry.status)
+	_, _ = rw.Write(entry.body)
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *responseCache) storeResponse(r *http.Request, capture *captureWriter, now time.Time) {
+	if capture.truncated || !cacheableStatus(capture.status) {
+		return
+	}
+	h := capture.header
+	directives := parseCacheControl(h)
+	for _, directive := range []string{"no-store", "private"} {
+		if _, ok := directives[directive]; ok {
+			return
+		}
+	}
+	if h.Get("Set-Cookie") != "" {
+		return
+	}
+	// Responses to authorized requests are personal unless the backend explicitly allows sharing them.
+	if r.Header.Get("Authorization") != "" {
+		_, public := directives["public"]
+		_, shared := directives["s-maxage"]
+		if !public && !shared {
+			return
+		}
+	}
+
+	vary := make(map[string]string)
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				vary[name] = strings.Join(r.Header.Values(name), ",")
+			}
+		}
+	}
+
+	lifetime := freshnessLifetime(h, now)
+	etag := h.Get("Etag")
+	// Without freshness information or an ETag to revalidate with, the response is useless to keep.
+	if lifetime <= 0 && etag == "" {
+		return
+	}
+
+	header := h.Clone()
+	// Trace headers describe how this particular response was served, not the resource.
+	for _, name := range []string{"Age", "lb-cache", "lb-from", "lb-hedge"} {
+		header.Del(name)
+	}
+	entry := &cacheEntry{
+		key:       cacheKey(r),
+		path:      r.URL.Path,
+		vary:      vary,
+		status:    capture.status,
+		header:    header,
+		body:      capture.body.Bytes(),
+		etag:      etag,
+		storedAt:  now,
+		expiresAt: now.Add(lifetime),
+	}
+	entry.size = len(entry.body) + len(entry.key)
+	for k, values := range header {
+		for _, v := range values {
+			entry.size += len(k) + len(v)
+		}
+	}
+	if entry.size > c.maxEntryBytes {
+		return
+	}
+	c.store(entry)
+}
+
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// freshnessLifetime reads how long a response stays fresh from s-maxage, max-age or Expires.
+// Responses marked no-cache are stored but always revalidated.
+func freshnessLifetime(h http.Header, now time.Time) time.Duration {
+	directives := parseCacheControl(h)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			if seconds, err := strconv.Atoi(value); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		return expiresAt.Sub(date)
+	}
+	return 0
+}
+
+func parseCacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// captureWriter records a response while passing it on to the client. Headers are kept apart from the
+// client's, which writers wrapping it, like compression, may change. When revalidating, a 304 is kept
+// from the client, which gets the stored response instead, while any other response is passed on.
+type captureWriter struct {
+	client       http.ResponseWriter
+	header       http.Header
+	status       int
+	body         bytes.Buffer
+	limit        int
+	truncated    bool
+	revalidating bool
+}
+
+func newCaptureWriter(client http.ResponseWriter, limit int) *captureWriter {
+	return &captureWriter{client: client, limit: limit, header: make(http.Header)}
+}
+
+func newRevalidationWriter(client http.ResponseWriter, limit int) *captureWriter {
+	return &captureWriter{client: client, limit: limit, header: make(http.Header), revalidating: true}
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if cw.notModified() {
+		return
+	}
+	for k, values := range cw.header {
+		cw.client.Header()[k] = append([]string(nil), values...)
+	}
+	cw.client.WriteHeader(status)
+}
+
+func (cw *captureWriter) notModified() bool {
+	return cw.revalidating && cw.status == http.StatusNotModified
+}
+
+func (cw *captureWriter) Write(data []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.notModified() {
+		return len(data), nil
+	}
+	if !cw.truncated {
+		if cw.body.Len()+len(data) > cw.limit {
+			// The response is too big to be cached, so there is no point in keeping it.
+			cw.truncated = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(data)
+		}
+	}
+	return cw.client.Write(data)
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.client
+}
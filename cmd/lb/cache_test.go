@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend answers with the given headers and counts the requests reaching it.
+type countingBackend struct {
+	calls   int
+	header  http.Header
+	body    string
+	lastReq *http.Request
+}
+
+func (b *countingBackend) serve(rw http.ResponseWriter, r *http.Request) {
+	b.calls++
+	b.lastReq = r
+	for k, v := range b.header {
+		rw.Header()[k] = v
+	}
+	if etag := b.header.Get("Etag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(b.body))
+}
+
+func newTestCache(t *testing.T, cfg CacheConfig) (*responseCache, *time.Time) {
+	c := newResponseCache(&cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func get(c *responseCache, backend *countingBackend, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	c.serve(rr, req, backend.serve)
+	return rr
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	c, now := newTestCache(t, CacheConfig{})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "data"}
+
+	assert.Equal(t, "data", get(c, backend, "/a").Body.String())
+	*now = now.Add(30 * time.Second)
+	rr := get(c, backend, "/a")
+	assert.Equal(t, "data", rr.Body.String())
+	assert.Equal(t, "30", rr.Header().Get("Age"))
+	assert.Equal(t, 1, backend.calls)
+
+	// Other URLs and requests asking to bypass the cache reach the backend.
+	get(c, backend, "/a?page=2")
+	get(c, backend, "/a", "Cache-Control", "no-store")
+	assert.Equal(t, 3, backend.calls)
+
+	*now = now.Add(31 * time.Second)
+	get(c, backend, "/a")
+	assert.Equal(t, 4, backend.calls)
+}
+
+func TestCacheExpires(t *testing.T) {
+	c, now := newTestCache(t, CacheConfig{})
+	backend := &countingBackend{header: http.Header{
+		"Date":    {now.UTC().Format(http.TimeFormat)},
+		"Expires": {now.Add(time.Minute).UTC().Format(http.TimeFormat)},
+	}}
+
+	get(c, backend, "/e")
+	get(c, backend, "/e")
+	assert.Equal(t, 1, backend.calls)
+}
+
+func TestCacheNotStored(t *testing.T) {
+	for name, header := range map[string]http.Header{
+		"No cache headers": {},
+		"no-store":         {"Cache-Control": {"no-store, max-age=60"}},
+		"private":          {"Cache-Control": {"private, max-age=60"}},
+		"Vary *":           {"Cache-Control": {"max-age=60"}, "Vary": {"*"}},
+		"Set-Cookie":       {"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newTestCache(t, CacheConfig{})
+			backend := &countingBackend{header: header}
+			get(c, backend, "/")
+			get(c, backend, "/")
+			assert.Equal(t, 2, backend.calls)
+		})
+	}
+}
+
+func TestCacheETag(t *testing.T) {
+	c, now := newTestCache(t, CacheConfig{})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}}, body: "data"}
+
+	get(c, backend, "/t")
+
+	t.Run("Client already has the fresh response", func(t *testing.T) {
+		rr := get(c, backend, "/t", "If-None-Match", `"v1"`)
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+		assert.Equal(t, 1, backend.calls)
+	})
+
+	t.Run("Stale response is revalidated", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		rr := get(c, backend, "/t")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "data", rr.Body.String())
+		assert.Equal(t, 2, backend.calls)
+		assert.Equal(t, `"v1"`, backend.lastReq.Header.Get("If-None-Match"))
+
+		// Revalidation made it fresh again.
+		get(c, backend, "/t")
+		assert.Equal(t, 2, backend.calls)
+	})
+
+	t.Run("Changed response replaces the stored one", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		backend.header.Set("Etag", `"v2"`)
+		backend.body = "new data"
+		assert.Equal(t, "new data", get(c, backend, "/t").Body.String())
+		assert.Equal(t, "new data", get(c, backend, "/t").Body.String())
+		assert.Equal(t, 3, backend.calls)
+	})
+}
+
+func TestCacheRevalidationTooBig(t *testing.T) {
+	c, now := newTestCache(t, CacheConfig{MaxEntryBytes: 100})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}}, body: "data"}
+	get(c, backend, "/t")
+
+	*now = now.Add(time.Minute)
+	backend.header.Set("Etag", `"v2"`)
+	backend.body = strings.Repeat("x", 200)
+	rr := get(c, backend, "/t")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"v2"`, rr.Header().Get("Etag"))
+	assert.Equal(t, backend.body, rr.Body.String())
+
+	// The changed response was passed on but not stored.
+	get(c, backend, "/t")
+	assert.Equal(t, 3, backend.calls)
+}
+
+func TestCacheVary(t *testing.T) {
+	c, _ := newTestCache(t, CacheConfig{})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}}
+
+	get(c, backend, "/v", "Accept-Language", "en")
+	get(c, backend, "/v", "Accept-Language", "uk")
+	get(c, backend, "/v", "Accept-Language", "en")
+	get(c, backend, "/v", "Accept-Language", "uk")
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, _ := newTestCache(t, CacheConfig{MaxBytes: 300, MaxEntryBytes: 200})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=60"}}, body: strings.Repeat("x", 100)}
+
+	get(c, backend, "/1")
+	get(c, backend, "/2")
+	get(c, backend, "/1")
+	get(c, backend, "/3")
+	assert.Equal(t, 3, backend.calls)
+	assert.LessOrEqual(t, c.size, 300)
+
+	// "/2" was the least recently used response.
+	get(c, backend, "/1")
+	assert.Equal(t, 3, backend.calls)
+	get(c, backend, "/2")
+	assert.Equal(t, 4, backend.calls)
+
+	// Responses bigger than an entry may be are passed through but not stored.
+	backend.body = strings.Repeat("y", 250)
+	assert.Len(t, get(c, backend, "/big").Body.String(), 250)
+	get(c, backend, "/big")
+	assert.Equal(t, 6, backend.calls)
+}
+
+func TestCachePurge(t *testing.T) {
+	c, _ := newTestCache(t, CacheConfig{})
+	backend := &countingBackend{header: http.Header{"Cache-Control": {"max-age=60"}}}
+	for _, path := range []string{"/api/a", "/api/b", "/static/c"} {
+		get(c, backend, path)
+	}
+
+	*adminToken = "secret"
+	defer func() { *adminToken = "" }()
+	rt := &router{cache: c}
+	purge := httptest.NewRequest("POST", "/cache/purge?prefix=/api/", nil)
+	rr := httptest.NewRecorder()
+	newAdminHandler(rt).ServeHTTP(rr, purge)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	purge.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	newAdminHandler(rt).ServeHTTP(rr, purge)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"purged": 2}`, rr.Body.String())
+
+	get(c, backend, "/api/a")
+	get(c, backend, "/static/c")
+	assert.Equal(t, 4, backend.calls)
+
+	rr = httptest.NewRecorder()
+	newAdminHandler(rt).ServeHTTP(rr, httptest.NewRequest("GET", "/cache/purge", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
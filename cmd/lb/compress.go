@@ -0,0 +1,201 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const defaultCompressionMinSize = 1024
+
+var defaultCompressibleTypes = []string{"application/json", "application/javascript", "text/*"}
+
+// compression encodes responses for clients sending Accept-Encoding.
+type compression struct {
+	contentTypes []string
+	minSize      int
+}
+
+func newCompression(cfg *CompressionConfig) *compression {
+	c := &compression{contentTypes: cfg.ContentTypes, minSize: cfg.MinSize}
+	if len(c.contentTypes) == 0 {
+		c.contentTypes = defaultCompressibleTypes
+	}
+	if c.minSize <= 0 {
+		c.minSize = defaultCompressionMinSize
+	}
+	return c
+}
+
+// compressible checks the media type against the allowlist, where "type/*" matches any subtype.
+func (c *compression) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks brotli or gzip from Accept-Encoding, preferring brotli on equal quality.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if name != "br" && name != "gzip" || quality <= 0 {
+			continue
+		}
+		if quality > bestQuality || quality == bestQuality && name == "br" {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// wrap returns a writer compressing the response if the client accepts it, and a function to finish it.
+func (c *compression) wrap(rw http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead {
+		return rw, func() {}
+	}
+	cw := &compressWriter{ResponseWriter: rw, compression: c, encoding: encoding}
+	return cw, cw.close
+}
+
+// compressWriter decides whether to compress when the response headers are written. If the body length
+// isn't known, up to minSize bytes are buffered to find out whether the body is worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	compression *compression
+	encoding    string
+
+	status      int
+	wroteHeader bool
+	buffered    []byte
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	h := cw.Header()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Encoding") != "" ||
+		!cw.compression.compressible(h.Get("Content-Type")) {
+		cw.writeHeader(false)
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if length := h.Get("Content-Length"); length != "" {
+		size, err := strconv.Atoi(length)
+		cw.writeHeader(err == nil && size >= cw.compression.minSize)
+	}
+}
+
+func (cw *compressWriter) writeHeader(compress bool) {
+	cw.wroteHeader = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// The encoded body differs byte by byte from the original one.
+		if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("Etag", "W/"+etag)
+		}
+		if cw.encoding == "br" {
+			cw.encoder = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.wroteHeader {
+		cw.buffered = append(cw.buffered, data...)
+		if len(cw.buffered) < cw.compression.minSize {
+			return len(data), nil
+		}
+		if err := cw.flushBuffered(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+func (cw *compressWriter) flushBuffered(compress bool) error {
+	cw.writeHeader(compress)
+	buffered := cw.buffered
+	cw.buffered = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buffered)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+// Flush passes everything written so far to the client, so streamed responses stay streamed.
+// A body of unknown length that is being streamed is compressed regardless of its size.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		return
+	}
+	if !cw.wroteHeader {
+		_ = cw.flushBuffered(true)
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close writes out what's left of the response once the handler has finished.
+func (cw *compressWriter) close() {
+	if cw.status != 0 && !cw.wroteHeader {
+		_ = cw.flushBuffered(false)
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Close()
+	}
+}
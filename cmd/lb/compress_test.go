@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "", negotiateEncoding(""))
+	assert.Equal(t, "", negotiateEncoding("identity, deflate"))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip"))
+	assert.Equal(t, "br", negotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "gzip", negotiateEncoding("br;q=0.5, gzip;q=0.8"))
+	assert.Equal(t, "gzip", negotiateEncoding("br;q=0, gzip"))
+}
+
+func TestCompressible(t *testing.T) {
+	c := newCompression(&CompressionConfig{})
+	assert.True(t, c.compressible("application/json; charset=utf-8"))
+	assert.True(t, c.compressible("text/html"))
+	assert.False(t, c.compressible("image/png"))
+	assert.False(t, c.compressible(""))
+}
+
+func compressed(t *testing.T, cfg CompressionConfig, acceptEncoding string, handler http.HandlerFunc) *http.Response {
+	c := newCompression(&cfg)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rr := httptest.NewRecorder()
+	rw, finish := c.wrap(rr, req)
+	handler(rw, req)
+	finish()
+	return rr.Result()
+}
+
+func decode(t *testing.T, resp *http.Response) string {
+	var reader io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		reader = gz
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	}
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompressWriter(t *testing.T) {
+	body := strings.Repeat(`{"key": "value"},`, 100)
+	jsonHandler := func(withLength bool) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("Etag", `"v1"`)
+			if withLength {
+				rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			rw.WriteHeader(http.StatusOK)
+			// Written in pieces to check that buffering keeps the order.
+			for i := 0; i < len(body); i += 100 {
+				_, _ = rw.Write([]byte(body[i:min(i+100, len(body))]))
+			}
+		}
+	}
+
+	for _, encoding := range []string{"gzip", "br"} {
+		for _, withLength := range []bool{true, false} {
+			t.Run(encoding+" length "+strconv.FormatBool(withLength), func(t *testing.T) {
+				resp := compressed(t, CompressionConfig{}, encoding, jsonHandler(withLength))
+				assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+				assert.Empty(t, resp.Header.Get("Content-Length"))
+				assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+				assert.Equal(t, `W/"v1"`, resp.Header.Get("Etag"))
+				assert.Equal(t, body, decode(t, resp))
+			})
+		}
+	}
+
+	t.Run("Small body", func(t *testing.T) {
+		resp := compressed(t, CompressionConfig{MinSize: 10000}, "gzip", jsonHandler(false))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, resp))
+	})
+
+	t.Run("Client doesn't accept encodings", func(t *testing.T) {
+		resp := compressed(t, CompressionConfig{}, "", jsonHandler(true))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, resp))
+	})
+
+	t.Run("Type not in allowlist", func(t *testing.T) {
+		resp := compressed(t, CompressionConfig{ContentTypes: []string{"text/*"}}, "gzip", jsonHandler(true))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, resp))
+	})
+
+	t.Run("Already encoded", func(t *testing.T) {
+		encoded := new(bytes.Buffer)
+		gz := gzip.NewWriter(encoded)
+		_, _ = gz.Write([]byte(body))
+		_ = gz.Close()
+		resp := compressed(t, CompressionConfig{}, "gzip, br", func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("Content-Encoding", "gzip")
+			_, _ = rw.Write(encoded.Bytes())
+		})
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, resp))
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		resp := compressed(t, CompressionConfig{}, "gzip", func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", "text/event-stream")
+			rw.WriteHeader(http.StatusOK)
+			_ = copyBody(rw, strings.NewReader("data: 1\n\n"), true)
+		})
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "data: 1\n\n", decode(t, resp))
+	})
+}
+
+func TestCompressedCache(t *testing.T) {
+	body := strings.Repeat(`{"key": "value"},`, 100)
+	backend := &countingBackend{
+		header: http.Header{"Content-Type": {"application/json"}, "Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}},
+		body:   body,
+	}
+	c, _ := newTestCache(t, CacheConfig{})
+	compression := newCompression(&CompressionConfig{})
+	// The same composition as in the router: compression wraps the cache.
+	get := func(acceptEncoding string) *http.Response {
+		req := httptest.NewRequest("GET", "/t", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		rw, finish := compression.wrap(rr, req)
+		c.serve(rw, req, backend.serve)
+		finish()
+		return rr.Result()
+	}
+
+	for _, encoding := range []string{"gzip", "br", "gzip", ""} {
+		resp := get(encoding)
+		assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, resp))
+	}
+	assert.Equal(t, 1, backend.calls)
+
+	// The cache keeps the identity response.
+	entry := c.lookup(httptest.NewRequest("GET", "/t", nil))
+	require.NotNil(t, entry)
+	assert.Empty(t, entry.header.Get("Content-Encoding"))
+	assert.Equal(t, `"v1"`, entry.etag)
+	assert.Equal(t, body, string(entry.body))
+}
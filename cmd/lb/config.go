@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultPoolName = "default"
+
+// Config describes backend pools and the routing rules choosing between them.
+type Config struct {
+	Pools  map[string]PoolConfig `json:"pools"`
+	Routes []RouteConfig         `json:"routes"`
+	// TrustedProxies are IPs or CIDRs whose X-Forwarded-For entries identify the real client.
+	TrustedProxies []string `json:"trustedProxies"`
+	// RateLimit applies to every client IP regardless of the route.
+	RateLimit *RateLimitConfig `json:"rateLimit"`
+	// Cache enables the in-memory cache of GET responses.
+	Cache *CacheConfig `json:"cache"`
+	// Compression enables gzip and brotli encoding of responses.
+	Compression *CompressionConfig `json:"compression"`
+	// MaxBodyBytes limits the size of request bodies unless a route sets its own limit.
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+}
+
+type CompressionConfig struct {
+	// ContentTypes lists compressible media types; "text/*" matches any subtype.
+	ContentTypes []string `json:"contentTypes"`
+	// MinSize is the smallest body worth compressing, in bytes.
+	MinSize int `json:"minSize"`
+}
+
+type CacheConfig struct {
+	// MaxBytes bounds the total size of stored responses, MaxEntryBytes the size of a single one.
+	MaxBytes      int `json:"maxBytes"`
+	MaxEntryBytes int `json:"maxEntryBytes"`
+}
+
+type PoolConfig struct {
+	Servers []string `json:"servers"`
+	// Discovery finds the servers at runtime instead of using the static Servers list.
+	Discovery *DiscoveryConfig `json:"discovery"`
+	// Strategy is one of "path-hash" (default), "round-robin", "least-conn", or the weighted
+	// "weighted-round-robin" and "weighted-least-conn".
+	Strategy string `json:"strategy"`
+	// Weights of servers for weighted strategies, 1 unless listed.
+	Weights map[string]int `json:"weights"`
+	// SlowStart is how long the weight of a recovered server ramps up to its full value.
+	SlowStart   Duration          `json:"slowStart"`
+	HealthCheck HealthCheckConfig `json:"healthCheck"`
+	// Affinity enables sticky sessions based on a signed cookie.
+	Affinity *AffinityConfig `json:"affinity"`
+	// CircuitBreaker stops routing to backends that keep failing or responding slowly.
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker"`
+	// Hedging duplicates slow GET requests to another backend.
+	Hedging *HedgingConfig `json:"hedging"`
+}
+
+type DiscoveryConfig struct {
+	// Type is "static" (default) to use the Servers list, "file" to read the list from a watched
+	// JSON file, "dns" to resolve Name, or "registration" to accept servers registering themselves.
+	Type string `json:"type"`
+	// File holds a JSON array of "host:port" addresses.
+	File string `json:"file"`
+	// Name is looked up for A/AAAA records combined with Port, or for SRV records when Port is zero.
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// Resolver is the "host:port" of the DNS server to query instead of the system one.
+	Resolver string `json:"resolver"`
+	// Interval is how often the servers are looked up again.
+	Interval Duration `json:"interval"`
+}
+
+type HealthCheckConfig struct {
+	// Type is "http" (default) to request Path, "tcp" to only check that a connection can be opened,
+	// "grpc" to use the gRPC health checking protocol, or "none" to consider servers always healthy
+	// (e.g. UDP-only ones).
+	Type     string   `json:"type"`
+	Path     string   `json:"path"`
+	Interval Duration `json:"interval"`
+	// Method and Headers of HTTP checks, GET without extra headers by default.
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// BodyRegex must match the body of HTTP responses.
+	BodyRegex string `json:"bodyRegex"`
+	// JSONField is a dot-separated path to a field that must be present in the JSON body of HTTP
+	// responses, and equal to JSONValue if it's set.
+	JSONField string `json:"jsonField"`
+	JSONValue string `json:"jsonValue"`
+	// Service is the name checked by gRPC, the whole server if empty.
+	Service string `json:"service"`
+}
+
+type AffinityConfig struct {
+	Cookie string   `json:"cookie"`
+	TTL    Duration `json:"ttl"`
+	Key    string   `json:"key"`
+}
+
+type CircuitBreakerConfig struct {
+	// ErrorRate is the share of failed requests within Window, once at least MinRequests were made,
+	// that opens the breaker.
+	ErrorRate   float64  `json:"errorRate"`
+	MinRequests int      `json:"minRequests"`
+	Window      Duration `json:"window"`
+	// SlowThreshold makes responses taking longer count as failures.
+	SlowThreshold Duration `json:"slowThreshold"`
+	// OpenTimeout is how long an open breaker rejects requests before letting HalfOpenRequests probes through.
+	OpenTimeout      Duration `json:"openTimeout"`
+	HalfOpenRequests int      `json:"halfOpenRequests"`
+}
+
+type HedgingConfig struct {
+	// Percentile (0-100) of recent response header latencies after which a GET request is hedged.
+	Percentile float64 `json:"percentile"`
+	// MinDelay is the shortest time to wait before hedging.
+	MinDelay Duration `json:"minDelay"`
+}
+
+// RouteConfig matches requests to a pool. Empty fields match anything; routes are evaluated in order.
+type RouteConfig struct {
+	Host       string            `json:"host"`
+	PathPrefix string            `json:"pathPrefix"`
+	PathRegex  string            `json:"pathRegex"`
+	Methods    []string          `json:"methods"`
+	Headers    map[string]string `json:"headers"`
+	Pool       string            `json:"pool"`
+	// StripPrefix removes PathPrefix from the forwarded path.
+	StripPrefix bool `json:"stripPrefix"`
+	// Rewrite replaces the matched PathPrefix, or is used as a replacement template for PathRegex.
+	Rewrite string `json:"rewrite"`
+	// RateLimit applies to every client IP within this route.
+	RateLimit *RateLimitConfig `json:"rateLimit"`
+	// MaxBodyBytes overrides the global request body limit.
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	// HeaderPolicy filters the request headers forwarded to the backend.
+	HeaderPolicy *HeaderPolicyConfig `json:"headerPolicy"`
+}
+
+// HeaderPolicyConfig lists header names, or prefixes ending with "*", to forward or drop.
+type HeaderPolicyConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// RateLimitConfig is a token bucket refilled with Rate tokens per second holding up to Burst tokens.
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// MaxClients bounds the number of tracked clients; the least recently seen ones are forgotten.
+	MaxClients int `json:"maxClients"`
+}
+
+// Duration is a time.Duration read from strings like "10s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfig sends everything to serversPool balanced by path hash.
+func defaultConfig() *Config {
+	return &Config{
+		Pools: map[string]PoolConfig{
+			defaultPoolName: {Servers: serversPool},
+		},
+		Routes: []RouteConfig{{Pool: defaultPoolName}},
+	}
+}
+
+func loadConfig(path string) (*Config, error) {
+	if path == "" {
+		return defaultConfig(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(cfg.Routes) == 0 && len(cfg.Pools) == 1 {
+		for name := range cfg.Pools {
+			cfg.Routes = []RouteConfig{{Pool: name}}
+		}
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "lb.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		cfg, err := loadConfig("")
+		require.NoError(t, err)
+		assert.Equal(t, serversPool, cfg.Pools[defaultPoolName].Servers)
+		assert.Equal(t, []RouteConfig{{Pool: defaultPoolName}}, cfg.Routes)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := writeConfig(t, `{
+			"pools": {
+				"api": {"servers": ["server1:8080"], "strategy": "round-robin",
+					"healthCheck": {"path": "/ready", "interval": "3s"}},
+				"static": {"servers": ["server2:8080"]}
+			},
+			"routes": [
+				{"pathPrefix": "/api/", "methods": ["GET"], "pool": "api", "stripPrefix": true},
+				{"pool": "static"}
+			]
+		}`)
+		cfg, err := loadConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, Duration(3*time.Second), cfg.Pools["api"].HealthCheck.Interval)
+		assert.Equal(t, "/ready", cfg.Pools["api"].HealthCheck.Path)
+		require.Len(t, cfg.Routes, 2)
+		assert.True(t, cfg.Routes[0].StripPrefix)
+	})
+
+	t.Run("Single pool without routes", func(t *testing.T) {
+		cfg, err := loadConfig(writeConfig(t, `{"pools": {"only": {"servers": ["s:1"]}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, []RouteConfig{{Pool: "only"}}, cfg.Routes)
+	})
+
+	t.Run("Bad duration", func(t *testing.T) {
+		_, err := loadConfig(writeConfig(t, `{"pools": {"p": {"healthCheck": {"interval": 10}}}}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+}
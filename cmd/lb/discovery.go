@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileDiscoveryInterval = 5 * time.Second
+	defaultDNSDiscoveryInterval  = 30 * time.Second
+	discoveryTimeout             = 5 * time.Second
+)
+
+// Discovery finds the addresses of the backends of a pool.
+type Discovery interface {
+	Lookup(ctx context.Context) ([]string, error)
+}
+
+// newDiscovery creates the provider described by the pool config along with its refresh interval.
+func newDiscovery(pool string, cfg PoolConfig) (Discovery, time.Duration, error) {
+	if cfg.Discovery == nil {
+		return staticDiscovery(cfg.Servers), 0, nil
+	}
+	interval := time.Duration(cfg.Discovery.Interval)
+	switch cfg.Discovery.Type {
+	case "", "static":
+		return staticDiscovery(cfg.Servers), 0, nil
+	case "file":
+		if cfg.Discovery.File == "" {
+			return nil, 0, fmt.Errorf("file discovery needs a file")
+		}
+		if interval <= 0 {
+			interval = defaultFileDiscoveryInterval
+		}
+		return &fileDiscovery{path: cfg.Discovery.File}, interval, nil
+	case "dns":
+		if cfg.Discovery.Name == "" {
+			return nil, 0, fmt.Errorf("dns discovery needs a name")
+		}
+		if interval <= 0 {
+			interval = defaultDNSDiscoveryInterval
+		}
+		d := &dnsDiscovery{name: cfg.Discovery.Name, port: cfg.Discovery.Port, resolver: net.DefaultResolver}
+		if cfg.Discovery.Resolver != "" {
+			d.resolver = stubResolver(cfg.Discovery.Resolver)
+		}
+		return d, interval, nil
+	case "registration":
+		if interval <= 0 {
+			interval = registrationDiscoveryInterval
+		}
+		return &registryDiscovery{registry: registrations, pool: pool}, interval, nil
+	}
+	return nil, 0, fmt.Errorf("unknown discovery type %q", cfg.Discovery.Type)
+}
+
+// staticDiscovery always returns the configured servers.
+type staticDiscovery []string
+
+func (d staticDiscovery) Lookup(context.Context) ([]string, error) {
+	return d, nil
+}
+
+// fileDiscovery reads servers from a JSON array in a file, parsing it again only after it was modified.
+type fileDiscovery struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	servers []string
+}
+
+func (d *fileDiscovery) Lookup(context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+	if d.servers != nil && info.ModTime().Equal(d.modTime) {
+		return d.servers, nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	servers := []string{}
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, fmt.Errorf("%s: %w", d.path, err)
+	}
+	d.modTime, d.servers = info.ModTime(), servers
+	return servers, nil
+}
+
+// resolver is the part of net.Resolver used for discovery, so that tests can stub it.
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// stubResolver sends DNS queries to the given server, e.g. 127.0.0.11:53 inside docker.
+func stubResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// dnsDiscovery resolves a name to servers. Addresses are sorted so that hash based strategies
+// don't reshuffle keys when a DNS server rotates its answers.
+type dnsDiscovery struct {
+	name     string
+	port     int
+	resolver resolver
+}
+
+func (d *dnsDiscovery) Lookup(ctx context.Context) ([]string, error) {
+	var servers []string
+	if d.port != 0 {
+		addrs, err := d.resolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			servers = append(servers, net.JoinHostPort(addr, strconv.Itoa(d.port)))
+		}
+	} else {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			servers = append(servers, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	}
+	sort.Strings(servers)
+	return servers, nil
+}
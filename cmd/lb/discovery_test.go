@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubDNS struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+	err   error
+}
+
+func (s *stubDNS) LookupHost(_ context.Context, host string) ([]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.hosts[host], nil
+}
+
+func (s *stubDNS) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if s.err != nil {
+		return "", nil, s.err
+	}
+	return name, s.srv[name], nil
+}
+
+func TestNewDiscovery(t *testing.T) {
+	d, interval, err := newDiscovery("test", PoolConfig{Servers: []string{"a:80"}})
+	require.NoError(t, err)
+	assert.Equal(t, staticDiscovery{"a:80"}, d)
+	assert.Zero(t, interval)
+
+	d, interval, err = newDiscovery("test", PoolConfig{Discovery: &DiscoveryConfig{Type: "dns", Name: "server", Port: 8080}})
+	require.NoError(t, err)
+	assert.IsType(t, &dnsDiscovery{}, d)
+	assert.Equal(t, defaultDNSDiscoveryInterval, interval)
+
+	for _, cfg := range []*DiscoveryConfig{{Type: "file"}, {Type: "dns"}, {Type: "consul"}} {
+		_, _, err := newDiscovery("test", PoolConfig{Discovery: cfg})
+		assert.Error(t, err, cfg.Type)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	require.NoError(t, os.WriteFile(path, []byte(`["a:80", "b:80"]`), 0o644))
+	d := &fileDiscovery{path: path}
+
+	servers, err := d.Lookup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:80", "b:80"}, servers)
+
+	require.NoError(t, os.WriteFile(path, []byte(`["c:80"]`), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	servers, err = d.Lookup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c:80"}, servers)
+
+	require.NoError(t, os.WriteFile(path, []byte(`not json`), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	_, err = d.Lookup(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	stub := &stubDNS{
+		hosts: map[string][]string{"server": {"10.0.0.2", "10.0.0.1"}},
+		srv: map[string][]*net.SRV{"_http._tcp.backend": {
+			{Target: "b.backend.", Port: 9000},
+			{Target: "a.backend.", Port: 9001},
+		}},
+	}
+
+	servers, err := (&dnsDiscovery{name: "server", port: 8080, resolver: stub}).Lookup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, servers)
+
+	servers, err = (&dnsDiscovery{name: "_http._tcp.backend", resolver: stub}).Lookup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.backend:9001", "b.backend:9000"}, servers)
+}
+
+func TestPoolDiscovery(t *testing.T) {
+	p, err := newPool("discovered", PoolConfig{
+		Discovery:   &DiscoveryConfig{Type: "dns", Name: "server", Port: 8080},
+		HealthCheck: HealthCheckConfig{Type: "none", Interval: Duration(time.Hour)},
+	})
+	require.NoError(t, err)
+	stub := &stubDNS{hosts: map[string][]string{"server": {"10.0.0.1"}}}
+	p.discovery.(*dnsDiscovery).resolver = stub
+
+	p.refresh()
+	p.startHealthChecks()
+	assert.Equal(t, []string{"10.0.0.1:8080"}, p.serverList())
+	assert.True(t, p.isHealthy("10.0.0.1:8080"))
+
+	// A scaled up replica is checked before it gets requests.
+	stub.hosts["server"] = []string{"10.0.0.1", "10.0.0.2"}
+	p.refresh()
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, p.serverList())
+	assert.Eventually(t, func() bool { return p.isHealthy("10.0.0.2:8080") }, time.Second, 10*time.Millisecond)
+	_, ok := inFlightCounter("10.0.0.2:8080")
+	assert.True(t, ok)
+
+	// Failed lookups keep the known servers.
+	stub.err = errors.New("no such host")
+	p.refresh()
+	assert.Len(t, p.serverList(), 2)
+
+	// So do empty ones.
+	stub.err = nil
+	stub.hosts["server"] = nil
+	p.refresh()
+	assert.Len(t, p.serverList(), 2)
+
+	stub.hosts["server"] = []string{"10.0.0.2"}
+	p.refresh()
+	assert.Equal(t, []string{"10.0.0.2:8080"}, p.serverList())
+	assert.False(t, p.isHealthy("10.0.0.1:8080"))
+	_, ok = inFlightCounter("10.0.0.1:8080")
+	assert.False(t, ok)
+	server, ok := p.pick("/")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.2:8080", server)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxHealthBodyBytes bounds how much of a health check response is read for matching.
+const maxHealthBodyBytes = 64 << 10
+
+// errDegraded is returned, wrapped with the details, by checks of servers that work but reported
+// being degraded. Such servers stay in rotation with a lower weight.
+var errDegraded = errors.New("degraded")
+
+// healthChecker probes a server and returns why it isn't healthy.
+type healthChecker interface {
+	check(ctx context.Context, server string) error
+}
+
+func newHealthChecker(cfg HealthCheckConfig) (healthChecker, error) {
+	switch cfg.Type {
+	case "", "http":
+		return newHTTPHealthCheck(cfg)
+	case "tcp":
+		return tcpHealthCheck{}, nil
+	case "grpc":
+		return &grpcHealthCheck{service: cfg.Service}, nil
+	case "none":
+		return noHealthCheck{}, nil
+	}
+	return nil, fmt.Errorf("unknown health check type %q", cfg.Type)
+}
+
+// noHealthCheck considers servers always healthy.
+type noHealthCheck struct{}
+
+func (noHealthCheck) check(context.Context, string) error {
+	return nil
+}
+
+// tcpHealthCheck only checks that a connection can be opened.
+type tcpHealthCheck struct{}
+
+func (tcpHealthCheck) check(ctx context.Context, server string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// httpHealthCheck expects a 200 response to the request, optionally with a body matching a regular
+// expression and a JSON field with the given value. A JSON body with "status": "degraded", as sent
+// by the readiness endpoint of our servers, marks the server degraded.
+type httpHealthCheck struct {
+	method    string
+	path      string
+	headers   map[string]string
+	bodyRegex *regexp.Regexp
+	jsonField []string
+	jsonValue string
+}
+
+func newHTTPHealthCheck(cfg HealthCheckConfig) (*httpHealthCheck, error) {
+	c := &httpHealthCheck{method: cfg.Method, path: cfg.Path, headers: cfg.Headers, jsonValue: cfg.JSONValue}
+	if c.method == "" {
+		c.method = http.MethodGet
+	}
+	if c.path == "" {
+		c.path = defaultHealthPath
+	}
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("health check body regex: %w", err)
+		}
+		c.bodyRegex = re
+	}
+	if cfg.JSONField != "" {
+		c.jsonField = strings.Split(cfg.JSONField, ".")
+	} else if cfg.JSONValue != "" {
+		return nil, fmt.Errorf("health check jsonValue needs a jsonField")
+	}
+	return c, nil
+}
+
+// healthClient sends HTTP health checks over the same transport as proxied requests, so that they
+// speak the protocol the backends do.
+var healthClient HttpClient = http.DefaultClient
+
+func (c *httpHealthCheck) check(ctx context.Context, server string) error {
+	req, err := http.NewRequestWithContext(ctx, c.method, fmt.Sprintf("%s://%s%s", scheme(), server, c.path), nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range c.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	isJSON := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+	if c.bodyRegex == nil && c.jsonField == nil && !isJSON {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodyBytes))
+	if err != nil {
+		return err
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return fmt.Errorf("body doesn't match %s", c.bodyRegex)
+	}
+	if c.jsonField != nil {
+		if err := c.matchJSON(body); err != nil {
+			return err
+		}
+	}
+	if isJSON {
+		return degradedStatus(body)
+	}
+	return nil
+}
+
+// degradedStatus returns errDegraded with the names of the degraded checks if the body is a degraded
+// health report, like {"status": "degraded", "checks": {"disk": {"status": "degraded"}}}.
+func degradedStatus(body []byte) error {
+	var report struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status string `json:"status"`
+		} `json:"checks"`
+	}
+	if json.Unmarshal(body, &report) != nil || report.Status != "degraded" {
+		return nil
+	}
+	var names []string
+	for name, check := range report.Checks {
+		if check.Status == "degraded" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return errDegraded
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%w: %s", errDegraded, strings.Join(names, ", "))
+}
+
+// matchJSON looks the dot-separated field up through objects and arrays and compares its value,
+// written as in JSON but without quotes for strings, to the expected one.
+func (c *httpHealthCheck) matchJSON(body []byte) error {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	field := strings.Join(c.jsonField, ".")
+	for _, name := range c.jsonField {
+		switch v := value.(type) {
+		case map[string]any:
+			var ok bool
+			if value, ok = v[name]; !ok {
+				return fmt.Errorf("no field %s", field)
+			}
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(v) {
+				return fmt.Errorf("no field %s", field)
+			}
+			value = v[i]
+		default:
+			return fmt.Errorf("no field %s", field)
+		}
+	}
+
+	actual, ok := value.(string)
+	if !ok {
+		encoded, _ := json.Marshal(value)
+		actual = string(encoded)
+	}
+	if c.jsonValue != "" && actual != c.jsonValue {
+		return fmt.Errorf("%s is %s, not %s", field, actual, c.jsonValue)
+	}
+	return nil
+}
+
+// gRPC health checking protocol (grpc.health.v1), spoken over HTTP/2 without generated code.
+const (
+	grpcHealthPath       = "/grpc.health.v1.Health/Check"
+	grpcStatusServing    = 1
+	grpcStatusNotServing = 2
+)
+
+var grpcStatusNames = map[uint64]string{0: "UNKNOWN", 1: "SERVING", 2: "NOT_SERVING", 3: "SERVICE_UNKNOWN"}
+
+// grpcHealthCheck calls Health/Check for the service, or for the whole server when it's empty,
+// and expects SERVING. Plain connections use HTTP/2 with prior knowledge, as gRPC servers do.
+type grpcHealthCheck struct {
+	service string
+}
+
+var grpcHealthClient = &http.Client{Transport: grpcTransport()}
+
+func grpcTransport() *http.Transport {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{
+		Protocols:       protocols,
+		TLSClientConfig: &tls.Config{NextProtos: []string{"h2"}},
+	}
+}
+
+func (c *grpcHealthCheck) check(ctx context.Context, server string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s://%s%s", scheme(), server, grpcHealthPath), bytes.NewReader(grpcFrame(c.request())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := grpcHealthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	message, readErr := readGRPCFrame(resp.Body)
+	// Trailers are only available once the body was read to the end.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthBodyBytes))
+	// Errors come as grpc-status in the trailers, or in the headers of a response without a body.
+	grpcStatus := resp.Trailer.Get("Grpc-Status")
+	if grpcStatus == "" {
+		grpcStatus = resp.Header.Get("Grpc-Status")
+	}
+	if grpcStatus != "" && grpcStatus != "0" {
+		return fmt.Errorf("grpc status %s: %s", grpcStatus, resp.Trailer.Get("Grpc-Message")+resp.Header.Get("Grpc-Message"))
+	}
+	if readErr != nil {
+		return readErr
+	}
+	status, err := protoVarintField(message, 1)
+	if err != nil {
+		return err
+	}
+	if status != grpcStatusServing {
+		name, ok := grpcStatusNames[status]
+		if !ok {
+			name = strconv.FormatUint(status, 10)
+		}
+		return fmt.Errorf("service is %s", name)
+	}
+	return nil
+}
+
+// request encodes HealthCheckRequest{service: c.service}.
+func (c *grpcHealthCheck) request() []byte {
+	if c.service == "" {
+		return nil
+	}
+	message := []byte{1<<3 | 2} // field 1, length-delimited
+	message = binary.AppendUvarint(message, uint64(len(c.service)))
+	return append(message, c.service...)
+}
+
+// grpcFrame prefixes an uncompressed message with its length.
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+func readGRPCFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading grpc response: %w", err)
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed grpc responses aren't supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxHealthBodyBytes {
+		return nil, fmt.Errorf("grpc response of %d bytes is too big", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("reading grpc response: %w", err)
+	}
+	return message, nil
+}
+
+// protoVarintField returns the value of a varint field of a protobuf message, zero if it's absent.
+func protoVarintField(message []byte, number uint64) (uint64, error) {
+	var result uint64
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("malformed protobuf message")
+		}
+		message = message[n:]
+		var size int
+		switch key & 7 {
+		case 0: // varint
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("malformed protobuf message")
+			}
+			if key>>3 == number {
+				result = value
+			}
+			size = n
+		case 1: // 64-bit
+			size = 8
+		case 2: // length-delimited
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return 0, errors.New("malformed protobuf message")
+			}
+			size = n + int(length)
+		case 5: // 32-bit
+			size = 4
+		default:
+			return 0, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+		if size > len(message) {
+			return 0, errors.New("malformed protobuf message")
+		}
+		message = message[size:]
+	}
+	return result, nil
+}
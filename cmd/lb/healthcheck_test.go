@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkWith(t *testing.T, cfg HealthCheckConfig, server string) error {
+	checker, err := newHealthChecker(cfg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return checker.check(ctx, server)
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	*https = false
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Probe") == "1" && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/health":
+			_, _ = rw.Write([]byte("OK"))
+		case "/status":
+			_, _ = rw.Write([]byte(`{"status": "up", "checks": [{"db": true}], "version": 2}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+	server := strings.TrimPrefix(backend.URL, "http://")
+
+	assert.NoError(t, checkWith(t, HealthCheckConfig{}, server))
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{Path: "/missing"}, server), "status 404")
+	assert.NoError(t, checkWith(t, HealthCheckConfig{BodyRegex: "^OK$"}, server))
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{BodyRegex: "FAILURE"}, server), "body doesn't match FAILURE")
+
+	headers := map[string]string{"X-Probe": "1"}
+	assert.Error(t, checkWith(t, HealthCheckConfig{Headers: headers}, server))
+	assert.NoError(t, checkWith(t, HealthCheckConfig{Method: http.MethodPost, Headers: headers}, server))
+
+	for field, value := range map[string]string{"status": "up", "checks.0.db": "true", "version": "2", "checks": ""} {
+		assert.NoError(t, checkWith(t, HealthCheckConfig{Path: "/status", JSONField: field, JSONValue: value}, server), field)
+	}
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{Path: "/status", JSONField: "status", JSONValue: "down"}, server),
+		"status is up, not down")
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{Path: "/status", JSONField: "checks.1.db"}, server),
+		"no field checks.1.db")
+	assert.Error(t, checkWith(t, HealthCheckConfig{JSONField: "status"}, server), "not JSON")
+}
+
+// grpcHealthServer implements grpc.health.v1.Health/Check over h2c: the whole server and "api" are
+// serving, "down" is not, and other services are unknown.
+func grpcHealthServer(t *testing.T) string {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthPath || r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request, err := readGRPCFrame(r.Body)
+		require.NoError(t, err)
+		service := ""
+		if len(request) > 0 {
+			length, n := binary.Uvarint(request[1:])
+			service = string(request[1+n : 1+n+int(length)])
+		}
+
+		rw.Header().Set("Content-Type", "application/grpc")
+		var status byte
+		switch service {
+		case "", "api":
+			status = grpcStatusServing
+		case "down":
+			status = grpcStatusNotServing
+		default:
+			rw.Header().Set("Grpc-Status", "5")
+			rw.Header().Set("Grpc-Message", "unknown service")
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(grpcFrame([]byte{1 << 3, status}))
+		rw.Header().Set("Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return strings.TrimPrefix(backend.URL, "http://")
+}
+
+func TestHTTPHealthCheckH2C(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			rw.WriteHeader(http.StatusHTTPVersionNotSupported)
+		}
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	*https = false
+	*upstreamH2C = true
+	healthClient = &http.Client{Transport: upstreamTransport()}
+	defer func() { *upstreamH2C, healthClient = false, http.DefaultClient }()
+
+	assert.NoError(t, checkWith(t, HealthCheckConfig{}, strings.TrimPrefix(backend.URL, "http://")))
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	*https = false
+	server := grpcHealthServer(t)
+
+	assert.NoError(t, checkWith(t, HealthCheckConfig{Type: "grpc"}, server))
+	assert.NoError(t, checkWith(t, HealthCheckConfig{Type: "grpc", Service: "api"}, server))
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{Type: "grpc", Service: "down"}, server), "service is NOT_SERVING")
+	assert.EqualError(t, checkWith(t, HealthCheckConfig{Type: "grpc", Service: "other"}, server), "grpc status 5: unknown service")
+}
+
+func TestGRPCMessages(t *testing.T) {
+	frame := grpcFrame((&grpcHealthCheck{service: "api"}).request())
+	assert.Equal(t, []byte{0, 0, 0, 0, 5, 0x0a, 3, 'a', 'p', 'i'}, frame)
+
+	message, err := readGRPCFrame(strings.NewReader(string(grpcFrame([]byte{0x0a, 1, 'x', 1 << 3, 2}))))
+	require.NoError(t, err)
+	status, err := protoVarintField(message, 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(grpcStatusNotServing), status)
+
+	_, err = protoVarintField([]byte{0x0a, 10, 'x'}, 1)
+	assert.Error(t, err)
+	_, err = readGRPCFrame(strings.NewReader("\x00\x00\x00"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestNewHealthChecker(t *testing.T) {
+	for _, cfg := range []HealthCheckConfig{{Type: "icmp"}, {BodyRegex: "("}, {JSONValue: "up"}} {
+		_, err := newHealthChecker(cfg)
+		assert.Error(t, err)
+	}
+}
+
+func TestHealthCheckReason(t *testing.T) {
+	events := collectEvents(t, "reasons")
+	p, err := newPool("reasons", PoolConfig{Servers: []string{"reason:8080"}})
+	require.NoError(t, err)
+	p.applyCheck("reason:8080", nil)
+	p.applyCheck("reason:8080", io.ErrUnexpectedEOF)
+	got := transitions(events())
+	assert.Equal(t, "healthy->unhealthy: health check failed: unexpected EOF", got[len(got)-1])
+}
+
+func TestDegradedHealthCheck(t *testing.T) {
+	*https = false
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/readyz":
+			_, _ = rw.Write([]byte(`{"status": "degraded", "checks": {"disk": {"status": "degraded"}, "goroutines": {"status": "degraded"}, "datastore": {"status": "ok"}}}`))
+		case "/healthz":
+			_, _ = rw.Write([]byte(`{"status": "ok", "checks": {}}`))
+		default:
+			_, _ = rw.Write([]byte(`["not", "a", "report"]`))
+		}
+	}))
+	defer backend.Close()
+	server := strings.TrimPrefix(backend.URL, "http://")
+
+	err := checkWith(t, HealthCheckConfig{Path: "/readyz"}, server)
+	assert.ErrorIs(t, err, errDegraded)
+	assert.EqualError(t, err, "degraded: disk, goroutines")
+	assert.NoError(t, checkWith(t, HealthCheckConfig{Path: "/healthz"}, server))
+	assert.NoError(t, checkWith(t, HealthCheckConfig{Path: "/other"}, server))
+
+	events := collectEvents(t, "degraded")
+	p, err := newPool("degraded", PoolConfig{Servers: []string{"degraded:8080", "fine:8080"}, Strategy: "weighted-round-robin"})
+	require.NoError(t, err)
+	p.applyCheck("fine:8080", nil)
+	p.applyCheck("degraded:8080", fmt.Errorf("%w: disk", errDegraded))
+	assert.True(t, p.isHealthy("degraded:8080"))
+	state := p.state.Load()
+	assert.True(t, state.backends["degraded:8080"].Degraded)
+	assert.Equal(t, []float64{degradedWeightFactor, 1}, p.effectiveWeights(state, p.servers, time.Now()))
+
+	p.applyCheck("degraded:8080", nil)
+	state = p.state.Load()
+	assert.False(t, state.backends["degraded:8080"].Degraded)
+	assert.Equal(t, []float64{1, 1}, p.effectiveWeights(state, p.servers, time.Now()))
+	// Being degraded doesn't take the server out of rotation.
+	assert.Equal(t, []string{
+		"unknown->unknown: added", "unknown->unknown: added",
+		"unknown->healthy: health check passed", "unknown->healthy: health check passed",
+	}, transitions(events()))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+const defaultHedgePercentile = 95
+
+// hedging duplicates slow GET requests to a second backend once the first one hasn't answered
+// within the given percentile of recently observed response header latencies.
+type hedging struct {
+	percentile float64
+	minDelay   time.Duration
+	latencies  *latencyWindow
+}
+
+func newHedging(cfg *HedgingConfig) *hedging {
+	h := &hedging{
+		percentile: cfg.Percentile,
+		minDelay:   time.Duration(cfg.MinDelay),
+		latencies:  newLatencyWindow(),
+	}
+	if h.percentile <= 0 || h.percentile > 100 {
+		h.percentile = defaultHedgePercentile
+	}
+	return h
+}
+
+// delay tells how long to wait before hedging. There is no delay until enough latencies were observed.
+func (h *hedging) delay() (time.Duration, bool) {
+	d, ok := h.latencies.percentile(h.percentile)
+	if !ok {
+		return 0, false
+	}
+	if d < h.minDelay {
+		d = h.minDelay
+	}
+	return d, true
+}
+
+// hedgeable tells whether a request can be sent twice. Only GET requests without a body are, so that
+// the two attempts don't read the same body.
+func hedgeable(r *http.Request) bool {
+	return r.Method == http.MethodGet && (r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0)
+}
+
+// attempt is one copy of a hedged request.
+type attempt struct {
+	dst    string
+	start  time.Time
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+	done   func()
+}
+
+// forwardHedged forwards a GET request to the primary server and, if it's slow to respond, to one more
+// server of the pool. The first response wins and the other request is cancelled. Outcomes of all
+// attempts are reported to the pool, except of those cancelled before they got an answer.
+//
+// Only latencies of successful primary attempts feed the hedging delay: those of winners alone would be
+// biased towards the fast ones, and quick failures would make hedging too eager.
+func forwardHedged(p *pool, primary string, rw http.ResponseWriter, r *http.Request) {
+	results := make(chan *attempt, 2)
+	var launched []*attempt
+	launch := func(dst string, done func()) *attempt {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		a := &attempt{dst: dst, start: time.Now(), cancel: cancel, done: done}
+		launched = append(launched, a)
+		go func() {
+			a.resp, a.err = roundTrip(ctx, dst, r, client)
+			results <- a
+		}()
+		return a
+	}
+	first := launch(primary, func() {})
+	pending := 1
+
+	var hedgeTimer <-chan time.Time
+	if delay, ok := p.hedging.delay(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	var winner *attempt
+	hedged := false
+	for winner == nil && pending > 0 {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if dst, ok := p.pickExcept(r.URL.Path, primary); ok {
+				launch(dst, trackInFlight(dst))
+				pending++
+				hedged = true
+				lbMetrics.hedgesSent.Add(1)
+			}
+		case a := <-results:
+			pending--
+			if a == first && a.err == nil && a.resp.StatusCode < http.StatusInternalServerError {
+				p.hedging.latencies.add(time.Since(a.start))
+			}
+			if a.err == nil {
+				winner = a
+			} else {
+				log.Printf("Failed to get response from %s: %s", a.dst, a.err)
+				a.finish(p, false)
+			}
+		}
+	}
+
+	if winner != nil && winner != first {
+		// The primary attempt is about to be cancelled, so its latency is at least as long as it took so far.
+		p.hedging.latencies.add(time.Since(first.start))
+	}
+
+	// The losers are cancelled now and cleaned up in the background.
+	for _, a := range launched {
+		if a != winner {
+			a.cancel()
+		}
+	}
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			a := <-results
+			a.cancel()
+			a.done()
+			switch {
+			case a.resp != nil:
+				// It answered before being cancelled.
+				a.resp.Body.Close()
+				p.report(a.dst, a.resp.StatusCode < http.StatusInternalServerError, time.Since(a.start))
+			case errors.Is(a.err, context.Canceled):
+				p.reportCancelled(a.dst)
+			default:
+				p.report(a.dst, false, time.Since(a.start))
+			}
+		}
+	}(pending)
+
+	if winner == nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if hedged {
+		// The trace header tells which of the two requests answered.
+		hedge := "primary"
+		if winner.dst != primary {
+			hedge = "secondary"
+			lbMetrics.hedgesWon.Add(1)
+		}
+		if *traceEnabled {
+			rw.Header().Set("lb-hedge", hedge)
+		}
+	}
+	status := winner.resp.StatusCode
+	writeResponse(winner.dst, rw, winner.resp)
+	winner.finish(p, status < http.StatusInternalServerError)
+}
+
+// finish releases the attempt's resources and reports its outcome.
+func (a *attempt) finish(p *pool, success bool) {
+	a.cancel()
+	a.done()
+	p.report(a.dst, success, time.Since(a.start))
+}
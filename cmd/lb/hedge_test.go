@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow()
+	_, ok := w.percentile(50)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	p50, ok := w.percentile(50)
+	require.True(t, ok)
+	assert.Equal(t, 50*time.Millisecond, p50)
+	p99, _ := w.percentile(99)
+	assert.Equal(t, 99*time.Millisecond, p99)
+
+	// Old samples are overwritten once the window is full.
+	for i := 0; i < latencyWindowSize; i++ {
+		w.add(time.Second)
+	}
+	p50, _ = w.percentile(50)
+	assert.Equal(t, time.Second, p50)
+}
+
+func TestHedgeable(t *testing.T) {
+	assert.True(t, hedgeable(httptest.NewRequest("GET", "/", nil)))
+	assert.False(t, hedgeable(httptest.NewRequest("GET", "/", strings.NewReader("body"))))
+	assert.False(t, hedgeable(httptest.NewRequest("POST", "/", nil)))
+}
+
+func TestForwardHedged(t *testing.T) {
+	*https = false
+	*traceEnabled = true
+	defer func() { *traceEnabled = false }()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		_, _ = rw.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	slowAddr := strings.TrimPrefix(slow.URL, "http://")
+	fastAddr := strings.TrimPrefix(fast.URL, "http://")
+	p, err := newPool("hedged", PoolConfig{
+		Servers: []string{slowAddr, fastAddr},
+		Hedging: &HedgingConfig{Percentile: 90, MinDelay: Duration(20 * time.Millisecond)},
+	})
+	require.NoError(t, err)
+	p.setHealthy(slowAddr, true)
+	p.setHealthy(fastAddr, true)
+
+	t.Run("Not enough samples", func(t *testing.T) {
+		sentBefore := lbMetrics.hedgesSent.Load()
+		rr := httptest.NewRecorder()
+		forwardHedged(p, fastAddr, rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "fast", rr.Body.String())
+		assert.Empty(t, rr.Header().Get("lb-hedge"))
+		assert.Equal(t, sentBefore, lbMetrics.hedgesSent.Load())
+	})
+
+	for i := 0; i < minLatencySamples; i++ {
+		p.hedging.latencies.add(time.Millisecond)
+	}
+	delay, ok := p.hedging.delay()
+	require.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, delay)
+
+	t.Run("Slow primary", func(t *testing.T) {
+		sentBefore, wonBefore := lbMetrics.hedgesSent.Load(), lbMetrics.hedgesWon.Load()
+		slowStats := p.state.Load().stats[slowAddr]
+		slowRequests := slowStats.requests.Load()
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		forwardHedged(p, slowAddr, rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Less(t, time.Since(start), 400*time.Millisecond)
+		assert.Equal(t, "fast", rr.Body.String())
+		assert.Equal(t, fastAddr, rr.Header().Get("lb-from"))
+		assert.Equal(t, "secondary", rr.Header().Get("lb-hedge"))
+		assert.Equal(t, sentBefore+1, lbMetrics.hedgesSent.Load())
+		assert.Equal(t, wonBefore+1, lbMetrics.hedgesWon.Load())
+		assert.Equal(t, int64(1), collectMetrics(nil).Hedges.Won-wonBefore)
+		// The cancelled primary request isn't counted in the backend's request metrics.
+		assert.Never(t, func() bool { return slowStats.requests.Load() != slowRequests }, 100*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("Fast primary", func(t *testing.T) {
+		sentBefore := lbMetrics.hedgesSent.Load()
+		rr := httptest.NewRecorder()
+		forwardHedged(p, fastAddr, rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "fast", rr.Body.String())
+		assert.Equal(t, sentBefore, lbMetrics.hedgesSent.Load())
+	})
+}
+
+func TestHedgingSkipsFailedLatencies(t *testing.T) {
+	*https = false
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	down.Close()
+
+	failingAddr := strings.TrimPrefix(failing.URL, "http://")
+	downAddr := strings.TrimPrefix(down.URL, "http://")
+	p, err := newPool("hedged-failures", PoolConfig{
+		Servers: []string{failingAddr, downAddr},
+		Hedging: &HedgingConfig{},
+	})
+	require.NoError(t, err)
+
+	for _, primary := range []string{failingAddr, downAddr} {
+		forwardHedged(p, primary, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assert.Empty(t, p.hedging.latencies.samples)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newAdminHandler(&router{}).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"hedges"`)
+}
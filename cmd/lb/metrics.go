@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lbMetrics are the balancer wide counters exposed by the admin API.
+var lbMetrics struct {
+	hedgesSent atomic.Int64
+	hedgesWon  atomic.Int64
+}
+
+// connStats are kept per backend for proxied connections in the tcp and udp modes.
+type connStats struct {
+	active   atomic.Int64
+	total    atomic.Int64
+	failed   atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+var (
+	connStatsMutex    sync.Mutex
+	connStatsByServer = make(map[string]*connStats)
+)
+
+func connStatsFor(server string) *connStats {
+	connStatsMutex.Lock()
+	defer connStatsMutex.Unlock()
+
+	stats, ok := connStatsByServer[server]
+	if !ok {
+		stats = new(connStats)
+		connStatsByServer[server] = stats
+	}
+	return stats
+}
+
+// requestStats count the requests proxied to a backend and keep its latest latencies.
+type requestStats struct {
+	requests  atomic.Int64
+	errors    atomic.Int64
+	latencies *latencyWindow
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{latencies: newLatencyWindow()}
+}
+
+// record counts a request. Tunnels and datagrams report no latency and are only counted.
+func (s *requestStats) record(success bool, latency time.Duration) {
+	s.requests.Add(1)
+	if !success {
+		s.errors.Add(1)
+	}
+	if latency > 0 {
+		s.latencies.add(latency)
+	}
+}
+
+// BackendMetrics follow the lifecycle events of a backend and the requests proxied to it. Errors are
+// the requests that failed or got a 5xx response; latency percentiles cover the latest requests.
+type BackendMetrics struct {
+	State       BackendState `json:"state"`
+	Reason      string       `json:"reason"`
+	Transitions int64        `json:"transitions"`
+	Requests    int64        `json:"requests"`
+	Errors      int64        `json:"errors"`
+	LatencyP50  Duration     `json:"latencyP50"`
+	LatencyP99  Duration     `json:"latencyP99"`
+}
+
+var (
+	backendMetricsMutex  sync.Mutex
+	backendMetricsByPool = make(map[string]map[string]*BackendMetrics)
+)
+
+// recordBackendEvent is subscribed to backend events to keep BackendMetrics up to date.
+func recordBackendEvent(event BackendEvent) {
+	backendMetricsMutex.Lock()
+	defer backendMetricsMutex.Unlock()
+
+	backends := backendMetricsByPool[event.Pool]
+	if backends == nil {
+		backends = make(map[string]*BackendMetrics)
+		backendMetricsByPool[event.Pool] = backends
+	}
+	if event.Reason == reasonRemoved {
+		delete(backends, event.Address)
+		return
+	}
+	m, ok := backends[event.Address]
+	if !ok {
+		m = new(BackendMetrics)
+		backends[event.Address] = m
+	}
+	m.State, m.Reason = event.To, event.Reason
+	if event.From != event.To {
+		m.Transitions++
+	}
+}
+
+type Metrics struct {
+	Hedges      HedgeMetrics                 `json:"hedges"`
+	InFlight    map[string]int64             `json:"inFlight"`
+	Connections map[string]ConnectionMetrics `json:"connections,omitempty"`
+	// Backends are the states of backends by pool and address.
+	Backends map[string]map[string]BackendMetrics `json:"backends,omitempty"`
+}
+
+type HedgeMetrics struct {
+	// Sent counts requests duplicated to a second backend, Won those answered by the second backend first.
+	Sent int64 `json:"sent"`
+	Won  int64 `json:"won"`
+}
+
+// ConnectionMetrics describe connections (or UDP sessions) to a backend. BytesIn were received from
+// clients and sent to the backend, BytesOut went the other way.
+type ConnectionMetrics struct {
+	Active   int64 `json:"active"`
+	Total    int64 `json:"total"`
+	Failed   int64 `json:"failed"`
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// collectMetrics gathers the balancer wide metrics and the request statistics of the pools' backends.
+func collectMetrics(pools map[string]*pool) Metrics {
+	m := Metrics{
+		Hedges: HedgeMetrics{
+			Sent: lbMetrics.hedgesSent.Load(),
+			Won:  lbMetrics.hedgesWon.Load(),
+		},
+	}
+	counters := inFlightCounters()
+	m.InFlight = make(map[string]int64, len(counters))
+	for server, counter := range counters {
+		m.InFlight[server] = counter.Load()
+	}
+
+	backendMetricsMutex.Lock()
+	for pool, backends := range backendMetricsByPool {
+		if m.Backends == nil {
+			m.Backends = make(map[string]map[string]BackendMetrics)
+		}
+		m.Backends[pool] = make(map[string]BackendMetrics, len(backends))
+		for address, metrics := range backends {
+			m.Backends[pool][address] = *metrics
+		}
+	}
+	backendMetricsMutex.Unlock()
+	for name, p := range pools {
+		backends := m.Backends[name]
+		for address, stats := range p.state.Load().stats {
+			metrics, ok := backends[address]
+			if !ok {
+				continue
+			}
+			// Percentiles stay zero until there are enough samples.
+			p50, _ := stats.latencies.percentile(50)
+			p99, _ := stats.latencies.percentile(99)
+			metrics.Requests, metrics.Errors = stats.requests.Load(), stats.errors.Load()
+			metrics.LatencyP50, metrics.LatencyP99 = Duration(p50), Duration(p99)
+			backends[address] = metrics
+		}
+	}
+
+	connStatsMutex.Lock()
+	defer connStatsMutex.Unlock()
+	if len(connStatsByServer) > 0 {
+		m.Connections = make(map[string]ConnectionMetrics, len(connStatsByServer))
+	}
+	for server, stats := range connStatsByServer {
+		m.Connections[server] = ConnectionMetrics{
+			Active:   stats.active.Load(),
+			Total:    stats.total.Load(),
+			Failed:   stats.failed.Load(),
+			BytesIn:  stats.bytesIn.Load(),
+			BytesOut: stats.bytesOut.Load(),
+		}
+	}
+	return m
+}
+
+func metricsHandler(rt *router) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, collectMetrics(rt.pools))
+	}
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthPath     = "/health"
+	defaultHealthInterval = 10 * time.Second
+	// slowStartMinFactor is the share of its weight a server gets right after recovering.
+	slowStartMinFactor = 0.1
+	// degradedWeightFactor is the share of its weight a degraded server gets.
+	degradedWeightFactor = 0.25
+	drainPollInterval    = 100 * time.Millisecond
+)
+
+// poolState is an immutable snapshot of the backends of a pool. Any change to them publishes a new one.
+type poolState struct {
+	servers  []string
+	healthy  []string
+	backends map[string]*Backend
+	breakers map[string]*circuitBreaker
+	stats    map[string]*requestStats
+}
+
+// pool is a named group of backends with its own balancing strategy and health checks.
+// Its servers are either configured statically or kept up to date by a discovery provider.
+type pool struct {
+	name            string
+	strategy        Strategy
+	healthInterval  time.Duration
+	checker         healthChecker
+	affinity        *affinity
+	breakerConfig   *CircuitBreakerConfig
+	hedging         *hedging
+	weights         map[string]int
+	slowStart       time.Duration
+	discovery       Discovery
+	refreshInterval time.Duration
+
+	// state is read by the request path without locking; mu serialises the updates publishing it.
+	state atomic.Pointer[poolState]
+
+	mu       sync.Mutex
+	servers  []string
+	breakers map[string]*circuitBreaker
+	stats    map[string]*requestStats
+	// checks stops the health check loop of each server once they were started.
+	checks   map[string]chan struct{}
+	backends map[string]*Backend
+	healthy  []string
+}
+
+func newPool(name string, cfg PoolConfig) (*pool, error) {
+	discovery, refreshInterval, err := newDiscovery(name, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("pool %q: %w", name, err)
+	}
+	static, isStatic := discovery.(staticDiscovery)
+	if isStatic && len(static) == 0 {
+		return nil, fmt.Errorf("pool %q has no servers", name)
+	}
+	strategy, err := newStrategy(cfg.Strategy)
+	if err != nil {
+		return nil, fmt.Errorf("pool %q: %w", name, err)
+	}
+	healthCheck := cfg.HealthCheck
+	checker, err := newHealthChecker(healthCheck)
+	if err != nil {
+		return nil, fmt.Errorf("pool %q: %w", name, err)
+	}
+	for server, weight := range cfg.Weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("pool %q: weight of %s must be positive", name, server)
+		}
+	}
+	if healthCheck.Interval <= 0 {
+		healthCheck.Interval = Duration(defaultHealthInterval)
+	}
+
+	var sticky *affinity
+	if cfg.Affinity != nil {
+		if sticky, err = newAffinity(cfg.Affinity); err != nil {
+			return nil, fmt.Errorf("pool %q: %w", name, err)
+		}
+	}
+
+	var hedge *hedging
+	if cfg.Hedging != nil {
+		hedge = newHedging(cfg.Hedging)
+	}
+
+	p := &pool{
+		name:           name,
+		strategy:       strategy,
+		healthInterval: time.Duration(healthCheck.Interval),
+		checker:        checker,
+		affinity:       sticky,
+		breakerConfig:  cfg.CircuitBreaker,
+		hedging:        hedge,
+		weights:        cfg.Weights,
+		slowStart:      time.Duration(cfg.SlowStart),
+		breakers:       make(map[string]*circuitBreaker),
+		stats:          make(map[string]*requestStats),
+		backends:       make(map[string]*Backend),
+	}
+	p.publish()
+	if isStatic {
+		p.setServers(static)
+	} else {
+		p.discovery, p.refreshInterval = discovery, refreshInterval
+	}
+	return p, nil
+}
+
+// pick chooses a healthy server for the key using the pool strategy, skipping servers whose circuit
+// breaker is open. It reads the published snapshot of the pool without taking the pool's lock, so health
+// checks and discovery updating the pool don't hold requests up.
+func (p *pool) pick(key string) (string, bool) {
+	return p.pickExcept(key, "")
+}
+
+// pickExcept is pick that never chooses the excluded server.
+func (p *pool) pickExcept(key, excluded string) (string, bool) {
+	state := p.state.Load()
+	candidates := state.healthy
+	if excluded != "" {
+		candidates = without(candidates, excluded)
+	}
+
+	now := time.Now()
+	for len(candidates) > 0 {
+		server := p.selectServer(state, key, candidates, now)
+		if state.acquire(server, now) {
+			return server, true
+		}
+		candidates = without(candidates, server)
+	}
+	return "", false
+}
+
+func (p *pool) selectServer(state *poolState, key string, servers []string, now time.Time) string {
+	if weighted, ok := p.strategy.(WeightedStrategy); ok {
+		return weighted.SelectWeighted(key, servers, p.effectiveWeights(state, servers, now))
+	}
+	return p.strategy.Select(key, servers)
+}
+
+// effectiveWeights returns the configured weights of the servers, reduced for degraded servers and for
+// those in their slow-start window after recovering from a failed health check: the weight ramps linearly
+// from slowStartMinFactor of its value to the full one.
+func (p *pool) effectiveWeights(state *poolState, servers []string, now time.Time) []float64 {
+	weights := make([]float64, len(servers))
+	for i, server := range servers {
+		weights[i] = 1
+		if weight, ok := p.weights[server]; ok {
+			weights[i] = float64(weight)
+		}
+		backend, ok := state.backends[server]
+		if !ok || backend.State != StateHealthy {
+			continue
+		}
+		if backend.Degraded {
+			weights[i] *= degradedWeightFactor
+		}
+		if backend.previous != StateUnhealthy {
+			continue
+		}
+		elapsed := now.Sub(backend.Since)
+		if elapsed >= p.slowStart {
+			continue
+		}
+		factor := slowStartMinFactor + (1-slowStartMinFactor)*float64(max(elapsed, 0))/float64(p.slowStart)
+		weights[i] *= factor
+	}
+	return weights
+}
+
+func without(servers []string, excluded string) []string {
+	rest := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if s != excluded {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+func (state *poolState) acquire(server string, now time.Time) bool {
+	breaker, ok := state.breakers[server]
+	return !ok || breaker.acquire(now)
+}
+
+func (p *pool) acquire(server string, now time.Time) bool {
+	return p.state.Load().acquire(server, now)
+}
+
+// report counts the request in the server's statistics and feeds its outcome to the server's circuit
+// breaker, ejecting the server when the breaker opens.
+func (p *pool) report(server string, success bool, latency time.Duration) {
+	state := p.state.Load()
+	if stats, ok := state.stats[server]; ok {
+		stats.record(success, latency)
+	}
+	breaker, ok := state.breakers[server]
+	if ok && breaker.record(success, latency, time.Now()) {
+		p.eject(server, breaker.openTimeout)
+	}
+}
+
+// reportCancelled is told about a request cancelled because another attempt answered first. That says
+// nothing about the backend and the client never saw it, so the request metrics leave it out and the
+// circuit breaker only gets its probe slot back.
+func (p *pool) reportCancelled(server string) {
+	if breaker, ok := p.state.Load().breakers[server]; ok {
+		breaker.release()
+	}
+}
+
+// eject takes a healthy server out of rotation until its circuit breaker lets probe requests through.
+func (p *pool) eject(server string, openTimeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backend, ok := p.backends[server]
+	if !ok || backend.State != StateHealthy {
+		return
+	}
+	ejected := p.transition(server, StateEjected, "circuit breaker opened")
+	time.AfterFunc(openTimeout, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.backends[server].sameTransition(ejected) {
+			p.transition(server, StateHealthy, "circuit breaker half-open")
+		}
+	})
+}
+
+// transition moves the server to a new state, notifying subscribers and publishing the pool state.
+// It must be called with the lock held.
+func (p *pool) transition(server string, to BackendState, reason string) *Backend {
+	now := time.Now()
+	backend := p.backends[server].transition(to, reason, now)
+	p.backends[server] = backend
+	p.notify(BackendEvent{Pool: p.name, Address: server, From: backend.previous, To: to, Reason: reason, Time: now})
+	p.updateHealthy()
+	return backend
+}
+
+// notify passes the event to the pool strategy, if it tracks backends, and to the subscribers.
+func (p *pool) notify(event BackendEvent) {
+	if observer, ok := p.strategy.(backendObserver); ok {
+		observer.backendChanged(event)
+	}
+	backendEvents.publish(event)
+}
+
+// setState lets an operator drain, disable or enable a server. Enabling restores the state given by
+// the last health check.
+func (p *pool) setState(server string, to BackendState) (*Backend, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backend, ok := p.backends[server]
+	if !ok {
+		return nil, errUnknownBackend
+	}
+	switch to {
+	case StateDraining:
+		if backend.State == StateDraining || backend.State == StateDisabled {
+			return nil, fmt.Errorf("%s is already %s", server, backend.State)
+		}
+		drained := p.transition(server, StateDraining, "draining requested")
+		go p.awaitDrained(server, drained)
+		return drained, nil
+	case StateDisabled:
+		if backend.State == StateDisabled {
+			return backend, nil
+		}
+		return p.transition(server, StateDisabled, "disabled by operator"), nil
+	case StateHealthy:
+		if backend.State != StateDraining && backend.State != StateDisabled {
+			return nil, fmt.Errorf("%s is %s, not draining or disabled", server, backend.State)
+		}
+		if !backend.checkPassed {
+			return p.transition(server, StateUnhealthy, "enabled by operator, failing health checks"), nil
+		}
+		return p.transition(server, StateHealthy, "enabled by operator"), nil
+	}
+	return nil, fmt.Errorf("can't set the state to %s", to)
+}
+
+var errUnknownBackend = errors.New("unknown backend")
+
+// awaitDrained disables the draining backend once it has no requests in flight.
+func (p *pool) awaitDrained(server string, draining *Backend) {
+	counter, _ := inFlightCounter(server)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if counter != nil && counter.Load() > 0 {
+			continue
+		}
+		p.mu.Lock()
+		if p.backends[server].sameTransition(draining) {
+			p.transition(server, StateDisabled, "drained")
+		}
+		p.mu.Unlock()
+		return
+	}
+}
+
+func (p *pool) serverList() []string {
+	return p.state.Load().servers
+}
+
+// setServers replaces the servers of the pool. New servers get a circuit breaker and a health check
+// loop and receive no requests until they pass a check; removed ones are forgotten.
+func (p *pool) setServers(servers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := make(map[string]bool, len(p.servers))
+	for _, s := range p.servers {
+		removed[s] = true
+	}
+	unique := make([]string, 0, len(servers))
+	seen := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		unique = append(unique, s)
+		if removed[s] {
+			delete(removed, s)
+			continue
+		}
+		registerInFlight(s)
+		p.backends[s] = &Backend{Address: s, Reason: reasonAdded, Since: time.Now()}
+		p.stats[s] = newRequestStats()
+		p.notify(BackendEvent{Pool: p.name, Address: s, Reason: reasonAdded, Time: time.Now()})
+		if p.breakerConfig != nil {
+			p.breakers[s] = newCircuitBreaker(p.breakerConfig)
+		}
+		if p.checks != nil {
+			p.checks[s] = p.watch(s, true)
+		}
+	}
+	for s := range removed {
+		backend := p.backends[s]
+		p.notify(BackendEvent{
+			Pool: p.name, Address: s, From: backend.State, To: StateDisabled, Reason: reasonRemoved, Time: time.Now(),
+		})
+		delete(p.breakers, s)
+		delete(p.stats, s)
+		delete(p.backends, s)
+		releaseInFlight(s)
+		if stop, ok := p.checks[s]; ok {
+			close(stop)
+			delete(p.checks, s)
+		}
+	}
+	p.servers = unique
+	p.updateHealthy()
+}
+
+// startDiscovery looks the servers up and keeps refreshing them in the background.
+func (p *pool) startDiscovery() {
+	if p.discovery == nil {
+		return
+	}
+	p.refresh()
+	go func() {
+		for range time.Tick(p.refreshInterval) {
+			p.refresh()
+		}
+	}()
+}
+
+// refresh keeps the current servers when the lookup fails, so that a DNS outage doesn't empty the pool.
+func (p *pool) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	servers, err := p.discovery.Lookup(ctx)
+	if err != nil {
+		log.Printf("[%s] discovery failed: %s", p.name, err)
+		return
+	}
+	// An empty DNS answer or a file caught mid-write is more likely a glitch than every backend gone,
+	// while registered servers do all leave.
+	if _, registry := p.discovery.(*registryDiscovery); len(servers) == 0 && !registry {
+		log.Printf("[%s] discovery found no servers, keeping the known ones", p.name)
+		return
+	}
+	p.setServers(servers)
+}
+
+func (p *pool) isHealthy(server string) bool {
+	backend, ok := p.state.Load().backends[server]
+	return ok && backend.State == StateHealthy
+}
+
+// backendList returns the backends in the order of servers.
+func (p *pool) backendList() []*Backend {
+	state := p.state.Load()
+	backends := make([]*Backend, 0, len(state.servers))
+	for _, server := range state.servers {
+		backends = append(backends, state.backends[server])
+	}
+	return backends
+}
+
+// pickFor chooses a server for the request, honouring the affinity cookie while its backend is healthy.
+// When a new choice is made for a sticky pool, the cookie pinning the client to it is set on the response.
+func (p *pool) pickFor(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	if p.affinity == nil {
+		return p.pick(r.URL.Path)
+	}
+	now := time.Now()
+	if server, ok := p.affinity.server(r, now); ok && p.isHealthy(server) && p.acquire(server, now) {
+		return server, true
+	}
+	server, ok := p.pick(r.URL.Path)
+	if ok {
+		http.SetCookie(rw, p.affinity.cookie(server, now, r.TLS != nil))
+	}
+	return server, ok
+}
+
+// startHealthChecks checks every server once before returning and then keeps checking them periodically.
+func (p *pool) startHealthChecks() {
+	for _, server := range p.serverList() {
+		p.checkServerHealth(server)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks = make(map[string]chan struct{}, len(p.servers))
+	for _, server := range p.servers {
+		p.checks[server] = p.watch(server, false)
+	}
+}
+
+// watch runs the health check loop of the server until the returned channel is closed.
+func (p *pool) watch(server string, checkNow bool) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.healthInterval)
+		defer ticker.Stop()
+		if checkNow {
+			p.checkServerHealth(server)
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.checkServerHealth(server)
+			}
+		}
+	}()
+	return stop
+}
+
+// Function to check server availability
+func (p *pool) checkServerHealth(server string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := p.checker.check(ctx, server)
+	log.Printf("\x1b[35m[%s] %s %t\x1b[0m", p.name, server, err == nil || errors.Is(err, errDegraded))
+	p.applyCheck(server, err)
+}
+
+func (p *pool) setHealthy(server string, isHealthy bool) {
+	var err error
+	if !isHealthy {
+		err = errHealthCheckFailed
+	}
+	p.applyCheck(server, err)
+}
+
+var errHealthCheckFailed = errors.New("health check failed")
+
+// applyCheck applies the result of a health check. Draining and disabled servers keep their state,
+// and ejected ones stay out of rotation until their circuit breaker lets requests through. Degraded
+// servers pass the check but are marked to get less traffic.
+func (p *pool) applyCheck(server string, checkErr error) {
+	degraded := errors.Is(checkErr, errDegraded)
+	isHealthy := checkErr == nil || degraded
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The server may have been removed by discovery while it was being checked.
+	backend, ok := p.backends[server]
+	if !ok {
+		return
+	}
+	if backend.checkPassed != isHealthy || backend.Degraded != degraded {
+		if degraded && !backend.Degraded {
+			log.Printf("[%s] %s: %s", p.name, server, checkErr)
+		} else if !degraded && backend.Degraded {
+			log.Printf("[%s] %s: no longer degraded", p.name, server)
+		}
+		updated := *backend
+		updated.checkPassed, updated.Degraded = isHealthy, degraded
+		p.backends[server] = &updated
+	}
+
+	switch {
+	case backend.State == StateDraining || backend.State == StateDisabled:
+		p.publish()
+	case !isHealthy && backend.State != StateUnhealthy:
+		reason := errHealthCheckFailed.Error()
+		if !errors.Is(checkErr, errHealthCheckFailed) {
+			reason += ": " + checkErr.Error()
+		}
+		p.transition(server, StateUnhealthy, reason)
+	case isHealthy && backend.State != StateHealthy && backend.State != StateEjected:
+		p.transition(server, StateHealthy, "health check passed")
+	default:
+		p.publish()
+	}
+}
+
+// updateHealthy keeps healthy servers in their configured order, so that hash based strategies
+// map keys the same way after restarts. It must be called with the lock held.
+func (p *pool) updateHealthy() {
+	healthy := make([]string, 0, len(p.servers))
+	for _, s := range p.servers {
+		if p.backends[s].State == StateHealthy {
+			healthy = append(healthy, s)
+		}
+	}
+	p.healthy = healthy
+	p.publish()
+}
+
+// publish makes the current backends visible to the request path. It must be called with the lock held.
+func (p *pool) publish() {
+	state := &poolState{
+		servers:  p.servers,
+		healthy:  p.healthy,
+		backends: make(map[string]*Backend, len(p.backends)),
+		breakers: make(map[string]*circuitBreaker, len(p.breakers)),
+		stats:    make(map[string]*requestStats, len(p.stats)),
+	}
+	for server, backend := range p.backends {
+		state.backends[server] = backend
+	}
+	for server, breaker := range p.breakers {
+		state.breakers[server] = breaker
+	}
+	for server, stats := range p.stats {
+		state.stats[server] = stats
+	}
+	p.state.Store(state)
+}
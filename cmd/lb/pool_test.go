@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolStateUpdatesDuringPicks(t *testing.T) {
+	servers := []string{"state1:8080", "state2:8080", "state3:8080"}
+	p, err := newPool("state", PoolConfig{Servers: servers, CircuitBreaker: &CircuitBreakerConfig{}})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.setHealthy(s, true)
+	}
+	snapshot := p.state.Load()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				server, ok := p.pick(fmt.Sprintf("/%d", j))
+				if ok {
+					p.report(server, true, 0)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		p.setHealthy(servers[j%3], j%2 == 0)
+	}
+	wg.Wait()
+
+	// Published snapshots are never modified.
+	assert.Equal(t, servers, snapshot.healthy)
+	assert.Len(t, snapshot.backends, 3)
+}
+
+// BenchmarkRouter measures the throughput of the request path with backends taking a millisecond to answer.
+// As requests don't wait for each other, the time per request drops in proportion to the number of
+// parallel clients (parallelism times GOMAXPROCS).
+func BenchmarkRouter(b *testing.B) {
+	previous := client
+	log.SetOutput(io.Discard)
+	defer func() {
+		client = previous
+		log.SetOutput(os.Stderr)
+	}()
+	client = &MockHttpClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+		time.Sleep(time.Millisecond)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`["1","2"]`)),
+			Request:    req,
+		}, nil
+	}}
+
+	rt, err := newRouter(defaultConfig())
+	require.NoError(b, err)
+	p := rt.pools[defaultPoolName]
+	for _, s := range p.serverList() {
+		p.setHealthy(s, true)
+	}
+
+	for _, parallelism := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					i++
+					rw := httptest.NewRecorder()
+					rt.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/some-data?key=%d", i), nil))
+					if rw.Code != http.StatusOK {
+						b.Fatalf("unexpected status %d", rw.Code)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkPoolPick(b *testing.B) {
+	servers := []string{"bench1:8080", "bench2:8080", "bench3:8080"}
+	p, err := newPool("bench", PoolConfig{Servers: servers, Strategy: "least-conn", CircuitBreaker: &CircuitBreakerConfig{}})
+	require.NoError(b, err)
+	for _, s := range servers {
+		p.setHealthy(s, true)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			server, _ := p.pick("/")
+			p.report(server, true, 0)
+		}
+	})
+}
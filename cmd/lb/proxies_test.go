@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name, remote, forwardedFor, expected string
+	}{
+		{"Direct client", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"Spoofed header from untrusted client", "203.0.113.5:1234", "1.2.3.4", "203.0.113.5"},
+		{"Trusted proxy", "10.1.2.3:1234", "198.51.100.7", "198.51.100.7"},
+		{"Chain of proxies", "10.1.2.3:1234", "1.2.3.4, 198.51.100.7, 192.168.1.1", "198.51.100.7"},
+		{"Only proxies", "[::1]:1234", "10.0.0.1", "10.0.0.1"},
+		{"Garbage in header", "10.1.2.3:1234", "unknown", "10.1.2.3"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = c.remote
+			if c.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", c.forwardedFor)
+			}
+			assert.Equal(t, c.expected, proxies.clientIP(req))
+		})
+	}
+
+	_, err = parseTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+	_, err = parseTrustedProxies([]string{"10.0.0.0/99"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitMaxClients = 10000
+
+// tokenBucket is the limiter state for a single key.
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per key. Only the most recently seen keys are kept,
+// so a flood of distinct clients can't grow its state without bound.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+func newRateLimiter(cfg *RateLimitConfig) (*rateLimiter, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive")
+	}
+	l := &rateLimiter{
+		rate:    cfg.Rate,
+		burst:   float64(cfg.Burst),
+		maxKeys: cfg.MaxClients,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+	if l.burst < 1 {
+		l.burst = math.Max(1, cfg.Rate)
+	}
+	if l.maxKeys <= 0 {
+		l.maxKeys = defaultRateLimitMaxClients
+	}
+	return l, nil
+}
+
+// allow takes a token for the key. When none is left it returns how long to wait for the next one.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var bucket *tokenBucket
+	if element, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+		elapsed := now.Sub(bucket.last).Seconds()
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		bucket.last = now
+	} else {
+		bucket = &tokenBucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(bucket)
+		if l.lru.Len() > l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).key)
+		}
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
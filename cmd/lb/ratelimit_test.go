@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(&RateLimitConfig{Rate: 2, Burst: 3})
+	require.NoError(t, err)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := l.allow("client")
+		assert.True(t, allowed, "request %d", i)
+	}
+	allowed, wait := l.allow("client")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own buckets.
+	allowed, _ = l.allow("other")
+	assert.True(t, allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = l.allow("client")
+	assert.True(t, allowed)
+	allowed, _ = l.allow("client")
+	assert.False(t, allowed)
+
+	_, err = newRateLimiter(&RateLimitConfig{})
+	assert.Error(t, err)
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	l, err := newRateLimiter(&RateLimitConfig{Rate: 1, Burst: 1, MaxClients: 2})
+	require.NoError(t, err)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.allow("a")
+	l.allow("b")
+	l.allow("a")
+	l.allow("c")
+	assert.Equal(t, 2, l.size())
+
+	// "b" was the least recently seen client, so it was forgotten and starts with a full bucket.
+	allowed, _ := l.allow("b")
+	assert.True(t, allowed)
+	allowed, _ = l.allow("c")
+	assert.False(t, allowed)
+}
+
+func TestRouterRateLimit(t *testing.T) {
+	rt, err := newRouter(&Config{
+		Pools:          map[string]PoolConfig{"p": {Servers: []string{"limited:8080"}}},
+		Routes:         []RouteConfig{{PathPrefix: "/slow", Pool: "p", RateLimit: &RateLimitConfig{Rate: 0.1, Burst: 1}}, {Pool: "p"}},
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	require.NoError(t, err)
+
+	request := func(path, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.2:5000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// No healthy servers, but the first request passes the limiter.
+	assert.Equal(t, http.StatusServiceUnavailable, request("/slow", "203.0.113.1").Code)
+	rr := request("/slow", "203.0.113.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+
+	// Other routes and other clients behind the same proxy aren't affected.
+	assert.Equal(t, http.StatusServiceUnavailable, request("/fast", "203.0.113.1").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, request("/slow", "203.0.113.2").Code)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRegistrationTTL        = 30 * time.Second
+	registrationDiscoveryInterval = time.Second
+)
+
+// registry keeps the servers that registered themselves with the balancer. A registration expires
+// unless it is renewed by a heartbeat within ttl.
+type registry struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	expires map[string]map[string]time.Time
+}
+
+func newRegistry(ttl time.Duration) *registry {
+	return &registry{ttl: ttl, now: time.Now, expires: make(map[string]map[string]time.Time)}
+}
+
+// registrations are shared by all pools using the "registration" discovery.
+var registrations = newRegistry(defaultRegistrationTTL)
+
+// register adds the server to the pool or extends its registration.
+func (rg *registry) register(pool, server string) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.expires[pool] == nil {
+		rg.expires[pool] = make(map[string]time.Time)
+	}
+	rg.expires[pool][server] = rg.now().Add(rg.ttl)
+}
+
+func (rg *registry) deregister(pool, server string) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	delete(rg.expires[pool], server)
+}
+
+// servers returns the live registrations of the pool, forgetting the expired ones.
+func (rg *registry) servers(pool string) []string {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	now := rg.now()
+	servers := []string{}
+	for server, expiresAt := range rg.expires[pool] {
+		if now.After(expiresAt) {
+			delete(rg.expires[pool], server)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// registryDiscovery provides the servers registered for a pool.
+type registryDiscovery struct {
+	registry *registry
+	pool     string
+}
+
+func (d *registryDiscovery) Lookup(context.Context) ([]string, error) {
+	return d.registry.servers(d.pool), nil
+}
+
+// Registration is the body of the POST and DELETE /register requests. An address without a host,
+// like ":8080", is completed with the IP the request came from.
+type Registration struct {
+	Pool    string `json:"pool"`
+	Address string `json:"address"`
+}
+
+type RegistrationResponse struct {
+	// TTL is how long the registration lasts without a heartbeat.
+	TTL Duration `json:"ttl"`
+}
+
+// registrationHandler lets servers add themselves to pools using the "registration" discovery.
+// Requests must carry the shared token as "Authorization: Bearer <token>"; without a token
+// registration is disabled.
+func registrationHandler(rt *router, rg *registry, token string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			rw.Header().Set("Allow", "POST, DELETE")
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var registration Registration
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096)).Decode(&registration); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		host, port, err := net.SplitHostPort(registration.Address)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if host == "" {
+			host = remoteIP(r)
+		}
+		address := net.JoinHostPort(host, port)
+
+		p, ok := rt.pools[registration.Pool]
+		if !ok {
+			http.Error(rw, "unknown pool", http.StatusNotFound)
+			return
+		}
+		if _, ok := p.discovery.(*registryDiscovery); !ok {
+			http.Error(rw, "pool doesn't accept registrations", http.StatusConflict)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			rg.deregister(registration.Pool, address)
+			p.refresh()
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rg.register(registration.Pool, address)
+		p.refresh()
+		writeJSON(rw, http.StatusOK, RegistrationResponse{TTL: Duration(rg.ttl)})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rg := newRegistry(30 * time.Second)
+	rg.now = func() time.Time { return now }
+
+	rg.register("api", "10.0.0.2:8080")
+	rg.register("api", "10.0.0.1:8080")
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, rg.servers("api"))
+	assert.Empty(t, rg.servers("other"))
+
+	// Only the first server keeps sending heartbeats.
+	now = now.Add(20 * time.Second)
+	rg.register("api", "10.0.0.1:8080")
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, []string{"10.0.0.1:8080"}, rg.servers("api"))
+
+	rg.deregister("api", "10.0.0.1:8080")
+	assert.Empty(t, rg.servers("api"))
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	rt, err := newRouter(&Config{
+		Pools: map[string]PoolConfig{
+			"api":    {Discovery: &DiscoveryConfig{Type: "registration"}, HealthCheck: HealthCheckConfig{Type: "none"}},
+			"static": {Servers: []string{"static:8080"}},
+		},
+		Routes: []RouteConfig{{Pool: "api"}},
+	})
+	require.NoError(t, err)
+	rg := newRegistry(time.Minute)
+	p := rt.pools["api"]
+	p.discovery = &registryDiscovery{registry: rg, pool: "api"}
+	handler := registrationHandler(rt, rg, "secret")
+
+	send := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/register", strings.NewReader(body))
+		r.RemoteAddr = "10.0.0.5:41000"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+		return rw
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "", `{"pool":"api","address":":8080"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "wrong", `{"pool":"api","address":":8080"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "secret", `{"pool":"nope","address":":8080"}`).Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "secret", `{"pool":"static","address":":8080"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "secret", `{"pool":"api","address":"8080"}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, send(http.MethodGet, "secret", "").Code)
+
+	rw := send(http.MethodPost, "secret", `{"pool":"api","address":":8080"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.JSONEq(t, `{"ttl":"1m0s"}`, rw.Body.String())
+	assert.Equal(t, []string{"10.0.0.5:8080"}, p.serverList())
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "secret", `{"pool":"api","address":"server2:8080"}`).Code)
+	assert.Equal(t, []string{"10.0.0.5:8080", "server2:8080"}, p.serverList())
+
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "secret", `{"pool":"api","address":":8080"}`).Code)
+	assert.Equal(t, []string{"server2:8080"}, p.serverList())
+
+	// Without a token registration is disabled.
+	rw = httptest.NewRecorder()
+	registrationHandler(rt, rg, "")(rw, httptest.NewRequest(http.MethodPost, "/register", nil))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+}
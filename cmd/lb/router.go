@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// route is a compiled RouteConfig.
+type route struct {
+	host         string
+	pathPrefix   string
+	pathRegex    *regexp.Regexp
+	methods      []string
+	headers      map[string]string
+	pool         *pool
+	stripPrefix  bool
+	rewrite      string
+	limiter      *rateLimiter
+	maxBody      int64
+	headerPolicy *headerPolicy
+}
+
+func (rt *route) matches(r *http.Request) bool {
+	if rt.host != "" && !hostMatches(rt.host, r.Host) {
+		return false
+	}
+	if rt.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	if rt.pathRegex != nil && !rt.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rt.methods) > 0 {
+		found := false
+		for _, method := range rt.methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for name, value := range rt.headers {
+		actual, present := r.Header[http.CanonicalHeaderKey(name)]
+		if !present || (value != "" && !contains(actual, value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatches compares the request host ignoring its port. A leading "*." matches any subdomain.
+func hostMatches(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// rewritePath returns the path the backend should see.
+func (rt *route) rewritePath(path string) string {
+	switch {
+	case rt.pathRegex != nil && rt.rewrite != "":
+		path = rt.pathRegex.ReplaceAllString(path, rt.rewrite)
+	case rt.pathPrefix != "" && (rt.stripPrefix || rt.rewrite != ""):
+		path = rt.rewrite + strings.TrimPrefix(path, rt.pathPrefix)
+	default:
+		return path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// router chooses a pool for each request and forwards it to a server picked by the pool.
+type router struct {
+	routes      []*route
+	pools       map[string]*pool
+	proxies     trustedProxies
+	limiter     *rateLimiter
+	cache       *responseCache
+	compression *compression
+	maxBody     int64
+}
+
+func newRouter(cfg *Config) (*router, error) {
+	rt := &router{pools: make(map[string]*pool), maxBody: cfg.MaxBodyBytes}
+	var err error
+	if rt.proxies, err = parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit != nil {
+		if rt.limiter, err = newRateLimiter(cfg.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Cache != nil {
+		rt.cache = newResponseCache(cfg.Cache)
+	}
+	if cfg.Compression != nil {
+		rt.compression = newCompression(cfg.Compression)
+	}
+	for name, poolConfig := range cfg.Pools {
+		p, err := newPool(name, poolConfig)
+		if err != nil {
+			return nil, err
+		}
+		rt.pools[name] = p
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("no routes configured")
+	}
+	for i, routeConfig := range cfg.Routes {
+		p, ok := rt.pools[routeConfig.Pool]
+		if !ok {
+			return nil, fmt.Errorf("route %d: unknown pool %q", i, routeConfig.Pool)
+		}
+		compiled := &route{
+			host:        routeConfig.Host,
+			pathPrefix:  routeConfig.PathPrefix,
+			methods:     routeConfig.Methods,
+			headers:     routeConfig.Headers,
+			pool:        p,
+			stripPrefix: routeConfig.StripPrefix,
+			rewrite:     routeConfig.Rewrite,
+			maxBody:     routeConfig.MaxBodyBytes,
+		}
+		if compiled.maxBody == 0 {
+			compiled.maxBody = cfg.MaxBodyBytes
+		}
+		if routeConfig.HeaderPolicy != nil {
+			compiled.headerPolicy = newHeaderPolicy(routeConfig.HeaderPolicy)
+		}
+		if routeConfig.PathRegex != "" {
+			re, err := regexp.Compile(routeConfig.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %w", i, err)
+			}
+			compiled.pathRegex = re
+		}
+		if routeConfig.RateLimit != nil {
+			if compiled.limiter, err = newRateLimiter(routeConfig.RateLimit); err != nil {
+				return nil, fmt.Errorf("route %d: %w", i, err)
+			}
+		}
+		rt.routes = append(rt.routes, compiled)
+	}
+	return rt, nil
+}
+
+func (rt *router) match(r *http.Request) *route {
+	for _, candidate := range rt.routes {
+		if candidate.matches(r) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// rateLimited answers 429 when the client has run out of tokens.
+func rateLimited(limiter *rateLimiter, key string, rw http.ResponseWriter) bool {
+	if limiter == nil {
+		return false
+	}
+	allowed, wait := limiter.allow(key)
+	if allowed {
+		return false
+	}
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	rw.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+func (rt *router) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ip := rt.proxies.clientIP(r)
+	if rateLimited(rt.limiter, ip, rw) {
+		return
+	}
+
+	r = r.Clone(r.Context())
+	if !rt.proxies.trusts(remoteIP(r)) {
+		stripInternalHeaders(r.Header)
+	}
+
+	matched := rt.match(r)
+	if matched == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if rateLimited(matched.limiter, ip, rw) {
+		return
+	}
+	if !limitBody(rw, r, matched.maxBody) {
+		return
+	}
+	if matched.headerPolicy != nil {
+		matched.headerPolicy.apply(r.Header)
+	}
+	setForwardedHeaders(r)
+
+	proxy := func(rw http.ResponseWriter, r *http.Request) {
+		if path := matched.rewritePath(r.URL.Path); path != r.URL.Path {
+			r = r.Clone(r.Context())
+			r.URL.Path = path
+			r.URL.RawPath = ""
+		}
+		rt.proxy(matched.pool, rw, r)
+	}
+	if isUpgradeRequest(r) {
+		proxy(rw, r)
+		return
+	}
+	if rt.compression != nil {
+		var finish func()
+		rw, finish = rt.compression.wrap(rw, r)
+		defer finish()
+	}
+	if rt.cache != nil {
+		rt.cache.serve(rw, r, proxy)
+		return
+	}
+	proxy(rw, r)
+}
+
+// proxy sends the request to a server of the pool.
+func (rt *router) proxy(p *pool, rw http.ResponseWriter, r *http.Request) {
+	dst, ok := p.pickFor(rw, r)
+	// Якщо немає доступних здорових серверів, повертаємо статус "Service Unavailable"
+	if !ok {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	done := trackInFlight(dst)
+	defer done()
+
+	recorder := &statusRecorder{ResponseWriter: rw}
+	start := time.Now()
+	if isUpgradeRequest(r) {
+		tunnel(dst, recorder, r)
+		// A tunnel lives as long as the client wants, so only its handshake says anything about the backend.
+		p.report(dst, recorder.status < http.StatusInternalServerError, 0)
+		return
+	}
+	if p.hedging != nil && hedgeable(r) {
+		forwardHedged(p, dst, rw, r)
+		return
+	}
+	forward(dst, recorder, r, client)
+	p.report(dst, recorder.status < http.StatusInternalServerError, time.Since(start))
+}
+
+// statusRecorder remembers the status code written to the response. It stays zero for hijacked connections.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(data)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRouter(t *testing.T) *router {
+	rt, err := newRouter(&Config{
+		Pools: map[string]PoolConfig{
+			"api":    {Servers: []string{"api1:8080", "api2:8080"}, Strategy: "round-robin"},
+			"admin":  {Servers: []string{"admin:8080"}},
+			"static": {Servers: []string{"static:8080"}},
+		},
+		Routes: []RouteConfig{
+			{Host: "admin.example.com", Pool: "admin"},
+			{PathPrefix: "/api/", Methods: []string{"GET", "POST"}, Pool: "api", Rewrite: "/v1/"},
+			{PathRegex: `^/users/(\d+)$`, Headers: map[string]string{"X-Beta": "1"}, Pool: "api", Rewrite: "/v2/users/$1"},
+			{PathPrefix: "/static", StripPrefix: true, Pool: "static"},
+		},
+	})
+	require.NoError(t, err)
+	return rt
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	_, err := newRouter(&Config{
+		Pools:  map[string]PoolConfig{"p": {Servers: []string{"s:1"}}},
+		Routes: []RouteConfig{{Pool: "missing"}},
+	})
+	assert.ErrorContains(t, err, "unknown pool")
+
+	_, err = newRouter(&Config{
+		Pools:  map[string]PoolConfig{"p": {Servers: []string{"s:1"}}},
+		Routes: []RouteConfig{{Pool: "p", PathRegex: "("}},
+	})
+	assert.Error(t, err)
+
+	_, err = newRouter(&Config{Pools: map[string]PoolConfig{"p": {}}})
+	assert.ErrorContains(t, err, "no servers")
+
+	_, err = newRouter(&Config{Pools: map[string]PoolConfig{"p": {Servers: []string{"s:1"}, Strategy: "magic"}}})
+	assert.ErrorContains(t, err, "unknown strategy")
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := testRouter(t)
+
+	cases := []struct {
+		name, method, target string
+		headers              map[string]string
+		pool, path           string
+	}{
+		{"Host with port", "GET", "http://admin.example.com:8090/anything", nil, "admin", "/anything"},
+		{"Prefix rewrite", "GET", "/api/items", nil, "api", "/v1/items"},
+		{"Method mismatch", "DELETE", "/api/items", nil, "", ""},
+		{"Regex with header", "GET", "/users/42", map[string]string{"X-Beta": "1"}, "api", "/v2/users/42"},
+		{"Regex without header", "GET", "/users/42", nil, "", ""},
+		{"Strip prefix", "GET", "/static/css/site.css", nil, "static", "/css/site.css"},
+		{"Strip whole path", "GET", "/static", nil, "static", "/"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.target, nil)
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			matched := rt.match(req)
+			if c.pool == "" {
+				assert.Nil(t, matched)
+				return
+			}
+			require.NotNil(t, matched)
+			assert.Equal(t, c.pool, matched.pool.name)
+			assert.Equal(t, c.path, matched.rewritePath(req.URL.Path))
+		})
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	assert.True(t, hostMatches("example.com", "EXAMPLE.com:443"))
+	assert.True(t, hostMatches("*.example.com", "api.example.com"))
+	assert.False(t, hostMatches("*.example.com", "example.com"))
+	assert.False(t, hostMatches("example.com", "example.org"))
+}
+
+func TestRouterServeHTTP(t *testing.T) {
+	rt := testRouter(t)
+	rt.pools["static"].setHealthy("static:8080", true)
+
+	var forwarded *http.Request
+	client = &MockHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			forwarded = req
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("ok")),
+				Header:     make(http.Header),
+				Request:    req,
+			}, nil
+		},
+	}
+	defer func() { client = http.DefaultClient }()
+
+	t.Run("Forwarded to the pool", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", "/static/app.js?v=2", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		require.NotNil(t, forwarded)
+		assert.Equal(t, "static:8080", forwarded.URL.Host)
+		assert.Equal(t, "/app.js", forwarded.URL.Path)
+		assert.Equal(t, "v=2", forwarded.URL.RawQuery)
+	})
+
+	t.Run("No healthy servers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", "/api/items", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+
+	t.Run("No route", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("PUT", "/api/items", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerPolicy filters request headers forwarded on a route. Names ending with "*" match by prefix.
+type headerPolicy struct {
+	allow []string
+	deny  []string
+}
+
+func newHeaderPolicy(cfg *HeaderPolicyConfig) *headerPolicy {
+	return &headerPolicy{allow: cfg.Allow, deny: cfg.Deny}
+}
+
+func headerNameMatches(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply removes denied headers and, if there is an allowlist, all headers not on it.
+func (hp *headerPolicy) apply(h http.Header) {
+	for name := range h {
+		if headerNameMatches(hp.deny, name) || len(hp.allow) > 0 && !headerNameMatches(hp.allow, name) {
+			h.Del(name)
+		}
+	}
+}
+
+// Headers the balancer itself adds to requests, which clients must not be able to forge.
+var internalHeaders = []string{"lb-*", "X-Forwarded-*", "Forwarded", "X-Real-Ip"}
+
+// stripInternalHeaders drops headers that only trusted proxies may set.
+func stripInternalHeaders(h http.Header) {
+	for name := range h {
+		if headerNameMatches(internalHeaders, name) {
+			h.Del(name)
+		}
+	}
+}
+
+// setForwardedHeaders tells the backend who the client is, extending the chain of trusted proxies.
+func setForwardedHeaders(r *http.Request) {
+	ip := remoteIP(r)
+	if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		ip = strings.Join(prior, ", ") + ", " + ip
+	}
+	r.Header.Set("X-Forwarded-For", ip)
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+}
+
+// limitBody rejects requests declaring a body over the limit with 413 and caps reading of the others.
+// Requests without a body are left alone, so that they can still be told apart, e.g. for hedging.
+func limitBody(rw http.ResponseWriter, r *http.Request, limit int64) bool {
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, limit)
+	return true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	h := http.Header{
+		"Accept":          {"*/*"},
+		"Authorization":   {"secret"},
+		"X-Internal-Id":   {"1"},
+		"X-Internal-Role": {"admin"},
+		"Content-Type":    {"text/plain"},
+	}
+
+	deny := newHeaderPolicy(&HeaderPolicyConfig{Deny: []string{"authorization", "X-Internal-*"}})
+	denied := h.Clone()
+	deny.apply(denied)
+	assert.Equal(t, http.Header{"Accept": {"*/*"}, "Content-Type": {"text/plain"}}, denied)
+
+	allow := newHeaderPolicy(&HeaderPolicyConfig{Allow: []string{"Accept", "X-Internal-*"}, Deny: []string{"X-Internal-Role"}})
+	allowed := h.Clone()
+	allow.apply(allowed)
+	assert.Equal(t, http.Header{"Accept": {"*/*"}, "X-Internal-Id": {"1"}}, allowed)
+}
+
+func TestStripInternalHeaders(t *testing.T) {
+	h := http.Header{
+		"Lb-Author":         {"spoofed"},
+		"Lb-From":           {"server1:8080"},
+		"X-Forwarded-For":   {"1.2.3.4"},
+		"X-Forwarded-Proto": {"https"},
+		"Accept":            {"*/*"},
+	}
+	stripInternalHeaders(h)
+	assert.Equal(t, http.Header{"Accept": {"*/*"}}, h)
+}
+
+func TestLimitBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	require.True(t, limitBody(rr, r, 4))
+	assert.Equal(t, http.NoBody, r.Body)
+	assert.True(t, hedgeable(r))
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader("12345"))
+	r.ContentLength = -1
+	require.True(t, limitBody(rr, r, 4))
+	_, err := io.ReadAll(r.Body)
+	assert.Error(t, err)
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader("12345"))
+	assert.False(t, limitBody(rr, r, 4))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestRouterSanitizesRequests(t *testing.T) {
+	*https = false
+	var received *http.Request
+	var receivedBody []byte
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var err error
+		receivedBody, err = io.ReadAll(r.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+		received = r
+	}))
+	defer backend.Close()
+	dst := strings.TrimPrefix(backend.URL, "http://")
+
+	rt, err := newRouter(&Config{
+		Pools: map[string]PoolConfig{"p": {Servers: []string{dst}}},
+		Routes: []RouteConfig{
+			{PathPrefix: "/upload", Pool: "p", MaxBodyBytes: 100},
+			{Pool: "p", HeaderPolicy: &HeaderPolicyConfig{Deny: []string{"Cookie"}}},
+		},
+		TrustedProxies: []string{"10.0.0.0/8"},
+		MaxBodyBytes:   10,
+	})
+	require.NoError(t, err)
+	rt.pools["p"].setHealthy(dst, true)
+
+	send := func(remote, path string, body io.Reader, headers map[string]string) int {
+		req := httptest.NewRequest("POST", path, body)
+		req.RemoteAddr = remote
+		if body != nil && path == "/stream" {
+			// Unknown length makes the limit apply while the body is being read.
+			req.ContentLength = -1
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Untrusted client", func(t *testing.T) {
+		code := send("203.0.113.9:1000", "/", nil, map[string]string{
+			"lb-author": "spoofed", "X-Forwarded-For": "1.1.1.1", "Cookie": "a=b", "Accept": "*/*",
+		})
+		require.Equal(t, http.StatusOK, code)
+		assert.Empty(t, received.Header.Get("lb-author"))
+		assert.Empty(t, received.Header.Get("Cookie"))
+		assert.Equal(t, "*/*", received.Header.Get("Accept"))
+		assert.Equal(t, "203.0.113.9", received.Header.Get("X-Forwarded-For"))
+		assert.Equal(t, "http", received.Header.Get("X-Forwarded-Proto"))
+	})
+
+	t.Run("Trusted proxy", func(t *testing.T) {
+		code := send("10.0.0.1:1000", "/", nil, map[string]string{"lb-author": "client", "X-Forwarded-For": "1.1.1.1"})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "client", received.Header.Get("lb-author"))
+		assert.Equal(t, "1.1.1.1, 10.0.0.1", received.Header.Get("X-Forwarded-For"))
+	})
+
+	t.Run("Body limits", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("203.0.113.9:1000", "/", strings.NewReader("small"), nil))
+		assert.Equal(t, "small", string(receivedBody))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send("203.0.113.9:1000", "/", strings.NewReader(strings.Repeat("x", 11)), nil))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send("203.0.113.9:1000", "/stream", strings.NewReader(strings.Repeat("x", 11)), nil))
+		// The route has its own, bigger limit.
+		assert.Equal(t, http.StatusOK, send("203.0.113.9:1000", "/upload", strings.NewReader(strings.Repeat("x", 50)), nil))
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy chooses one of the healthy servers. The key identifies the request (its path for HTTP),
+// so that strategies relying on it can keep the choice stable.
+type Strategy interface {
+	Select(key string, servers []string) string
+}
+
+// WeightedStrategy is a Strategy that takes into account the effective weight of each server,
+// which is lowered for servers warming up after recovery.
+type WeightedStrategy interface {
+	Strategy
+	SelectWeighted(key string, servers []string, weights []float64) string
+}
+
+func newStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", "path-hash":
+		return pathHash{}, nil
+	case "round-robin":
+		return new(roundRobin), nil
+	case "least-conn":
+		return leastConn{}, nil
+	case "weighted-round-robin":
+		return &weightedRoundRobin{current: make(map[string]float64)}, nil
+	case "weighted-least-conn":
+		return weightedLeastConn{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q", name)
+}
+
+// pathHash sends the same key to the same server as long as the set of healthy servers doesn't change.
+type pathHash struct{}
+
+func (pathHash) Select(key string, servers []string) string {
+	return servers[int(hash(key))%len(servers)]
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (rr *roundRobin) Select(_ string, servers []string) string {
+	n := rr.next.Add(1) - 1
+	return servers[n%uint64(len(servers))]
+}
+
+// leastConn picks the server with the fewest in-flight requests, preferring the first on ties.
+type leastConn struct{}
+
+func (leastConn) Select(_ string, servers []string) string {
+	best, bestCount := servers[0], int64(-1)
+	for _, server := range servers {
+		var count int64
+		if counter, ok := inFlightCounter(server); ok {
+			count = counter.Load()
+		}
+		if bestCount == -1 || count < bestCount {
+			best, bestCount = server, count
+		}
+	}
+	return best
+}
+
+// backendObserver is implemented by strategies keeping state per backend.
+type backendObserver interface {
+	backendChanged(event BackendEvent)
+}
+
+func equalWeights(n int) []float64 {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights
+}
+
+// weightedRoundRobin is the smooth weighted round robin used by nginx: servers are picked in
+// proportion to their weights without sending several requests in a row to the heaviest one.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[string]float64
+}
+
+func (w *weightedRoundRobin) Select(key string, servers []string) string {
+	return w.SelectWeighted(key, servers, equalWeights(len(servers)))
+}
+
+func (w *weightedRoundRobin) SelectWeighted(_ string, servers []string, weights []float64) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	best, total := 0, 0.0
+	for i, server := range servers {
+		w.current[server] += weights[i]
+		total += weights[i]
+		if w.current[server] > w.current[servers[best]] {
+			best = i
+		}
+	}
+	w.current[servers[best]] -= total
+	return servers[best]
+}
+
+// backendChanged drops the credit a backend accumulated before leaving the rotation, so that it doesn't
+// get a burst of requests when it comes back.
+func (w *weightedRoundRobin) backendChanged(event BackendEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.current, event.Address)
+}
+
+// weightedLeastConn picks the server with the fewest in-flight requests per unit of weight.
+type weightedLeastConn struct{}
+
+func (wl weightedLeastConn) Select(key string, servers []string) string {
+	return wl.SelectWeighted(key, servers, equalWeights(len(servers)))
+}
+
+func (weightedLeastConn) SelectWeighted(_ string, servers []string, weights []float64) string {
+	best, bestScore := 0, -1.0
+	for i, server := range servers {
+		var count int64
+		if counter, ok := inFlightCounter(server); ok {
+			count = counter.Load()
+		}
+		// Counting the request being placed lets idle servers be told apart by their weights.
+		score := float64(count+1) / weights[i]
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return servers[best]
+}
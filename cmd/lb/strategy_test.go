@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range []string{"", "path-hash", "round-robin", "least-conn", "weighted-round-robin", "weighted-least-conn"} {
+		_, err := newStrategy(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := newStrategy("random")
+	assert.Error(t, err)
+}
+
+func TestPathHash(t *testing.T) {
+	servers := []string{"a", "b", "c"}
+	strategy := pathHash{}
+	assert.Equal(t, strategy.Select("/some/path", servers), strategy.Select("/some/path", servers))
+	assert.Equal(t, servers[int(hash("/x"))%3], strategy.Select("/x", servers))
+}
+
+func TestRoundRobin(t *testing.T) {
+	servers := []string{"a", "b", "c"}
+	strategy := new(roundRobin)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, strategy.Select("", servers))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, got)
+}
+
+func TestLeastConn(t *testing.T) {
+	servers := []string{"lc1:8080", "lc2:8080", "lc3:8080"}
+	counters := make(map[string]*atomic.Int64)
+	for _, s := range servers {
+		registerInFlight(s)
+		counters[s], _ = inFlightCounter(s)
+	}
+	counters["lc1:8080"].Store(3)
+	counters["lc2:8080"].Store(1)
+	counters["lc3:8080"].Store(2)
+
+	strategy, err := newStrategy("least-conn")
+	require.NoError(t, err)
+	assert.Equal(t, "lc2:8080", strategy.Select("", servers))
+
+	counters["lc2:8080"].Store(5)
+	assert.Equal(t, "lc3:8080", strategy.Select("", servers))
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	servers := []string{"a", "b", "c"}
+	strategy := &weightedRoundRobin{current: make(map[string]float64)}
+	var got []string
+	for i := 0; i < 7; i++ {
+		got = append(got, strategy.SelectWeighted("", servers, []float64{5, 1, 1}))
+	}
+	// The heavy server gets its share without taking all the requests in a row.
+	assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a"}, got)
+}
+
+func TestWeightedLeastConn(t *testing.T) {
+	servers := []string{"wlc1:8080", "wlc2:8080"}
+	for _, s := range servers {
+		registerInFlight(s)
+	}
+	first, _ := inFlightCounter("wlc1:8080")
+	second, _ := inFlightCounter("wlc2:8080")
+	first.Store(2)
+	second.Store(1)
+
+	strategy := weightedLeastConn{}
+	assert.Equal(t, "wlc2:8080", strategy.Select("", servers))
+	// 3 requests per 4 units of weight are fewer than 2 per 1.
+	assert.Equal(t, "wlc1:8080", strategy.SelectWeighted("", servers, []float64{4, 1}))
+}
+
+func TestSlowStart(t *testing.T) {
+	p, err := newPool("slow-start", PoolConfig{
+		Servers:   []string{"ss1:8080", "ss2:8080"},
+		Strategy:  "weighted-round-robin",
+		Weights:   map[string]int{"ss1:8080": 2},
+		SlowStart: Duration(10 * time.Second),
+	})
+	require.NoError(t, err)
+	p.setHealthy("ss1:8080", true)
+	p.setHealthy("ss2:8080", true)
+
+	now := time.Now()
+	// Servers healthy since the first check get their full weight.
+	assert.Equal(t, []float64{2, 1}, p.effectiveWeights(p.state.Load(), p.servers, now))
+
+	p.setHealthy("ss2:8080", false)
+	p.setHealthy("ss2:8080", true)
+	state := p.state.Load()
+	recoveredAt := state.backends["ss2:8080"].Since
+	weights := p.effectiveWeights(state, p.servers, recoveredAt)
+	assert.InDelta(t, 0.1, weights[1], 1e-9)
+	weights = p.effectiveWeights(state, p.servers, recoveredAt.Add(5*time.Second))
+	assert.InDelta(t, 0.55, weights[1], 1e-9)
+	weights = p.effectiveWeights(state, p.servers, recoveredAt.Add(10*time.Second))
+	assert.Equal(t, []float64{2, 1}, weights)
+
+	_, err = newPool("bad-weight", PoolConfig{Servers: []string{"a:80"}, Weights: map[string]int{"a:80": 0}})
+	assert.Error(t, err)
+}
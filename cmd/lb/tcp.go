@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// tcpProxy balances raw TCP connections between the servers of a pool.
+type tcpProxy struct {
+	pool        *pool
+	idleTimeout time.Duration
+}
+
+func (tp *tcpProxy) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go tp.handle(conn)
+	}
+}
+
+func (tp *tcpProxy) handle(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	// Hashing the client address keeps its connections on the same backend with the path-hash strategy.
+	clientAddr := clientConn.RemoteAddr().String()
+	key, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		key = clientAddr
+	}
+	dst, ok := tp.pool.pick(key)
+	if !ok {
+		log.Printf("No healthy servers for connection from %s", clientAddr)
+		return
+	}
+
+	stats := connStatsFor(dst)
+	stats.total.Add(1)
+	start := time.Now()
+	backendConn, err := net.DialTimeout("tcp", dst, timeout)
+	if err != nil {
+		log.Printf("Failed to connect to %s: %s", dst, err)
+		stats.failed.Add(1)
+		tp.pool.report(dst, false, time.Since(start))
+		return
+	}
+	defer backendConn.Close()
+	tp.pool.report(dst, true, time.Since(start))
+
+	done := trackInFlight(dst)
+	defer done()
+	stats.active.Add(1)
+	defer stats.active.Add(-1)
+	log.Println("tcp", clientAddr, "->", dst)
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+	errc := make(chan error, 2)
+	go func() {
+		errc <- pipe(backendConn, clientConn, tp.idleTimeout, &lastActivity, &stats.bytesIn)
+	}()
+	go func() {
+		errc <- pipe(clientConn, backendConn, tp.idleTimeout, &lastActivity, &stats.bytesOut)
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			// An error or idle timeout in one direction ends the whole connection.
+			log.Printf("tcp %s -> %s closed: %s", clientAddr, dst, err)
+			return
+		}
+	}
+}
+
+// pipe copies data from src to dst until src is drained, then half-closes dst. Reading fails once
+// neither direction of the connection has seen any traffic for idleTimeout.
+func pipe(dst, src net.Conn, idleTimeout time.Duration, lastActivity, bytes *atomic.Int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if idleTimeout > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			bytes.Add(int64(n))
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			if tcpConn, ok := dst.(*net.TCPConn); ok {
+				_ = tcpConn.CloseWrite()
+			}
+			return nil
+		}
+		if err != nil {
+			var netErr net.Error
+			idle := time.Since(time.Unix(0, lastActivity.Load()))
+			if errors.As(err, &netErr) && netErr.Timeout() && idle < idleTimeout {
+				continue
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoTCPServer echoes everything back on each connection.
+func echoTCPServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func startTCPProxy(t *testing.T, servers []string, idleTimeout time.Duration) (string, *pool) {
+	p, err := newPool("tcp-"+t.Name(), PoolConfig{Servers: servers, HealthCheck: HealthCheckConfig{Type: "tcp"}})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.checkServerHealth(s)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go (&tcpProxy{pool: p, idleTimeout: idleTimeout}).serve(listener)
+	return listener.Addr().String(), p
+}
+
+func TestTCPProxy(t *testing.T) {
+	backend := echoTCPServer(t)
+	defer backend.Close()
+	dst := backend.Addr().String()
+	addr, _ := startTCPProxy(t, []string{dst}, time.Minute)
+
+	stats := connStatsFor(dst)
+	totalBefore := stats.total.Load()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	for _, msg := range []string{"hello\n", "world\n"} {
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, msg, line)
+	}
+	assert.Equal(t, totalBefore+1, stats.total.Load())
+	assert.Equal(t, int64(1), stats.active.Load())
+	assert.Equal(t, int64(12), collectMetrics(nil).Connections[dst].BytesOut)
+
+	// Half-closing the client side lets the backend finish and the proxy close the connection.
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	_, err = reader.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return stats.active.Load() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(12), stats.bytesIn.Load())
+}
+
+func TestTCPProxyIdleTimeout(t *testing.T) {
+	backend := echoTCPServer(t)
+	defer backend.Close()
+	addr, _ := startTCPProxy(t, []string{backend.Addr().String()}, 100*time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Traffic keeps the connection open past the idle timeout.
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		_, err := conn.Write([]byte("x"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 1))
+		require.NoError(t, err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestTCPHealth(t *testing.T) {
+	backend := echoTCPServer(t)
+	dst := backend.Addr().String()
+	assert.NoError(t, tcpHealthCheck{}.check(context.Background(), dst))
+
+	backend.Close()
+	assert.Error(t, tcpHealthCheck{}.check(context.Background(), dst))
+
+	_, err := newPool("bad-check", PoolConfig{Servers: []string{dst}, HealthCheck: HealthCheckConfig{Type: "icmp"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const maxDatagramSize = 64 * 1024
+
+// udpSession relays datagrams between one client address and the backend chosen for it.
+type udpSession struct {
+	client       *net.UDPAddr
+	backend      string
+	upstream     *net.UDPConn
+	lastActivity atomic.Int64
+}
+
+// udpProxy balances UDP traffic. Each client address sticks to a backend until its session is idle
+// for sessionTimeout.
+type udpProxy struct {
+	pool           *pool
+	sessionTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+func newUDPProxy(p *pool, sessionTimeout time.Duration) *udpProxy {
+	return &udpProxy{pool: p, sessionTimeout: sessionTimeout, sessions: make(map[string]*udpSession)}
+}
+
+func (up *udpProxy) serve(conn *net.UDPConn) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, clientAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if err := up.relay(conn, clientAddr, buf[:n]); err != nil {
+			log.Printf("Dropping datagram from %s: %s", clientAddr, err)
+		}
+	}
+}
+
+// relay sends a client datagram to the backend of its session. A session ended while the datagram
+// was on its way, or whose backend refused the previous one, is replaced by a new session.
+func (up *udpProxy) relay(conn *net.UDPConn, clientAddr *net.UDPAddr, datagram []byte) error {
+	session, err := up.session(conn, clientAddr)
+	if err != nil {
+		return err
+	}
+	connStatsFor(session.backend).bytesIn.Add(int64(len(datagram)))
+	_, err = session.upstream.Write(datagram)
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNREFUSED) {
+		up.end(session)
+		if session, err = up.session(conn, clientAddr); err != nil {
+			return err
+		}
+		_, err = session.upstream.Write(datagram)
+	}
+	return err
+}
+
+var errNoHealthyServers = errors.New("no healthy servers")
+
+// session returns the client's session, starting a new one with a backend picked by the pool strategy.
+// The session is marked active under the lock, so it can't expire before the datagram is relayed.
+func (up *udpProxy) session(conn *net.UDPConn, clientAddr *net.UDPAddr) (*udpSession, error) {
+	key := clientAddr.String()
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if session, ok := up.sessions[key]; ok {
+		session.lastActivity.Store(time.Now().UnixNano())
+		return session, nil
+	}
+
+	dst, ok := up.pool.pick(clientAddr.IP.String())
+	if !ok {
+		return nil, errNoHealthyServers
+	}
+	stats := connStatsFor(dst)
+	stats.total.Add(1)
+	backendAddr, err := net.ResolveUDPAddr("udp", dst)
+	var upstream *net.UDPConn
+	if err == nil {
+		upstream, err = net.DialUDP("udp", nil, backendAddr)
+	}
+	if err != nil {
+		stats.failed.Add(1)
+		up.pool.report(dst, false, 0)
+		return nil, err
+	}
+	up.pool.report(dst, true, 0)
+
+	session := &udpSession{client: clientAddr, backend: dst, upstream: upstream}
+	session.lastActivity.Store(time.Now().UnixNano())
+	up.sessions[key] = session
+	stats.active.Add(1)
+	log.Println("udp", key, "->", dst)
+	go up.relayReplies(conn, session)
+	return session, nil
+}
+
+// relayReplies sends backend datagrams back to the client until the session expires.
+func (up *udpProxy) relayReplies(conn *net.UDPConn, session *udpSession) {
+	stats := connStatsFor(session.backend)
+	done := trackInFlight(session.backend)
+	defer func() {
+		up.end(session)
+		stats.active.Add(-1)
+		done()
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		_ = session.upstream.SetReadDeadline(time.Now().Add(up.sessionTimeout))
+		n, err := session.upstream.Read(buf)
+		if n > 0 {
+			session.lastActivity.Store(time.Now().UnixNano())
+			stats.bytesOut.Add(int64(n))
+			if _, err := conn.WriteToUDP(buf[:n], session.client); err != nil {
+				log.Printf("Failed to relay datagram to %s: %s", session.client, err)
+			}
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if up.expire(session) {
+					return
+				}
+				continue
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("udp session %s -> %s failed: %s", session.client, session.backend, err)
+			}
+			return
+		}
+	}
+}
+
+// expire removes the session if it has been idle for sessionTimeout. The check is made under the lock
+// serve takes to mark sessions active, so a session being relayed to stays.
+func (up *udpProxy) expire(session *udpSession) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if time.Since(time.Unix(0, session.lastActivity.Load())) < up.sessionTimeout {
+		return false
+	}
+	up.remove(session)
+	return true
+}
+
+// end removes the session before closing its upstream, so that serve starts a new session instead
+// of picking the closed one.
+func (up *udpProxy) end(session *udpSession) {
+	up.mu.Lock()
+	up.remove(session)
+	up.mu.Unlock()
+	_ = session.upstream.Close()
+}
+
+// remove deletes the session from the map unless a new session of the client replaced it already.
+func (up *udpProxy) remove(session *udpSession) {
+	key := session.client.String()
+	if up.sessions[key] == session {
+		delete(up.sessions, key)
+	}
+}
+
+func (up *udpProxy) sessionCount() int {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return len(up.sessions)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoUDPServer sends every datagram back to its sender.
+func echoUDPServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func startUDPProxy(t *testing.T, servers []string, sessionTimeout time.Duration) (*net.UDPAddr, *udpProxy) {
+	p, err := newPool("udp-"+t.Name(), PoolConfig{Servers: servers, HealthCheck: HealthCheckConfig{Type: "none"}})
+	require.NoError(t, err)
+	for _, s := range servers {
+		p.checkServerHealth(s)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	proxy := newUDPProxy(p, sessionTimeout)
+	go proxy.serve(conn)
+	return conn.LocalAddr().(*net.UDPAddr), proxy
+}
+
+func exchange(t *testing.T, conn *net.UDPConn, msg string) string {
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestUDPProxy(t *testing.T) {
+	backend := echoUDPServer(t)
+	dst := backend.LocalAddr().String()
+	addr, proxy := startUDPProxy(t, []string{dst}, time.Minute)
+
+	stats := connStatsFor(dst)
+	totalBefore := stats.total.Load()
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range []string{"hello", "world"} {
+		assert.Equal(t, msg, exchange(t, conn, msg))
+	}
+	// Both datagrams went through the same session.
+	assert.Equal(t, 1, proxy.sessionCount())
+	assert.Equal(t, totalBefore+1, stats.total.Load())
+	assert.Equal(t, int64(10), collectMetrics(nil).Connections[dst].BytesOut)
+
+	other, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer other.Close()
+	assert.Equal(t, "again", exchange(t, other, "again"))
+	assert.Equal(t, 2, proxy.sessionCount())
+}
+
+func TestUDPSessionTimeout(t *testing.T) {
+	backend := echoUDPServer(t)
+	dst := backend.LocalAddr().String()
+	addr, proxy := startUDPProxy(t, []string{dst}, 100*time.Millisecond)
+	stats := connStatsFor(dst)
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Traffic keeps the session alive past the timeout.
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, "x", exchange(t, conn, "x"))
+	}
+	assert.Equal(t, 1, proxy.sessionCount())
+
+	assert.Eventually(t, func() bool { return proxy.sessionCount() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), stats.active.Load())
+
+	// A client coming back starts a new session.
+	assert.Equal(t, "y", exchange(t, conn, "y"))
+	assert.Equal(t, 1, proxy.sessionCount())
+}
+
+func TestUDPSessionClosed(t *testing.T) {
+	backend := echoUDPServer(t)
+	addr, proxy := startUDPProxy(t, []string{backend.LocalAddr().String()}, time.Minute)
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "x", exchange(t, conn, "x"))
+
+	// A session closing while datagrams arrive is replaced instead of dropping them.
+	proxy.mu.Lock()
+	for _, session := range proxy.sessions {
+		_ = session.upstream.Close()
+	}
+	proxy.mu.Unlock()
+	assert.Equal(t, "y", exchange(t, conn, "y"))
+	assert.Equal(t, 1, proxy.sessionCount())
+}
+
+func TestUDPProxyWithoutHealthyServers(t *testing.T) {
+	p, err := newPool("udp-down", PoolConfig{Servers: []string{"127.0.0.1:1"}, HealthCheck: HealthCheckConfig{Type: "none"}})
+	require.NoError(t, err)
+	proxy := newUDPProxy(p, time.Minute)
+
+	_, err = proxy.session(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000})
+	assert.ErrorIs(t, err, errNoHealthyServers)
+	assert.Equal(t, 0, proxy.sessionCount())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// isUpgradeRequest reports whether the client asks to switch protocols (e.g. to WebSocket).
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func dialBackend(ctx context.Context, dst string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	if *https {
+		host, _, _ := net.SplitHostPort(dst)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		return tlsDialer.DialContext(ctx, "tcp", dst)
+	}
+	return dialer.DialContext(ctx, "tcp", dst)
+}
+
+// tunnel passes an upgrade request to the backend and, once the backend switches protocols,
+// hijacks the client connection and splices bytes in both directions until either side closes.
+func tunnel(dst string, rw http.ResponseWriter, r *http.Request) error {
+	backendConn, err := dialBackend(r.Context(), dst)
+	if err != nil {
+		log.Printf("Failed to connect to %s: %s", dst, err)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return err
+	}
+	defer backendConn.Close()
+
+	fwdRequest := r.Clone(r.Context())
+	fwdRequest.URL.Host = dst
+	fwdRequest.Host = dst
+
+	_ = backendConn.SetDeadline(time.Now().Add(timeout))
+	if err := fwdRequest.Write(backendConn); err != nil {
+		log.Printf("Failed to send upgrade request to %s: %s", dst, err)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return err
+	}
+	backendReader := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(backendReader, fwdRequest)
+	if err != nil {
+		log.Printf("Failed to get upgrade response from %s: %s", dst, err)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return err
+	}
+	_ = backendConn.SetDeadline(time.Time{})
+
+	if *traceEnabled {
+		resp.Header.Set("lb-from", dst)
+	}
+
+	// The backend refused to switch protocols, so its answer is an ordinary response.
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		for k, values := range resp.Header {
+			for _, value := range values {
+				rw.Header().Add(k, value)
+			}
+		}
+		log.Println("fwd", resp.StatusCode, r.URL)
+		rw.WriteHeader(resp.StatusCode)
+		_, err := io.Copy(rw, resp.Body)
+		return err
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		log.Printf("Failed to hijack client connection: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	defer clientConn.Close()
+	// The frontend server might have set deadlines that make no sense for a long-lived tunnel.
+	_ = clientConn.SetDeadline(time.Time{})
+
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	_ = resp.Header.Write(clientBuf)
+	_, _ = clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return err
+	}
+	log.Println("tunnel", dst, r.URL)
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backendConn, clientBuf.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(clientConn, backendReader)
+		errc <- err
+	}()
+	return <-errc
+}
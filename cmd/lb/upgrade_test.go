@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws", nil)
+	assert.False(t, isUpgradeRequest(req))
+
+	req.Header.Set("Upgrade", "websocket")
+	assert.False(t, isUpgradeRequest(req))
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, isUpgradeRequest(req))
+}
+
+// echoUpgradeBackend switches to a line-based echo protocol on upgrade requests.
+func echoUpgradeBackend(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte("upgrade required"))
+			return
+		}
+		conn, buf, err := http.NewResponseController(rw).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		_ = buf.Flush()
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = buf.WriteString(line)
+			_ = buf.Flush()
+		}
+	}))
+}
+
+func TestTunnel(t *testing.T) {
+	*https = false
+	backend := echoUpgradeBackend(t)
+	defer backend.Close()
+	dst := strings.TrimPrefix(backend.URL, "http://")
+
+	frontend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_ = tunnel(dst, rw, r)
+	}))
+	defer frontend.Close()
+
+	t.Run("Switching protocols", func(t *testing.T) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(frontend.URL, "http://"))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		for _, msg := range []string{"ping\n", "pong\n"} {
+			_, err = conn.Write([]byte(msg))
+			require.NoError(t, err)
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, msg, line)
+		}
+	})
+
+	t.Run("Upgrade refused", func(t *testing.T) {
+		resp, err := http.Get(frontend.URL + "/plain")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestTunnelBackendUnavailable(t *testing.T) {
+	*https = false
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dst := listener.Addr().String()
+	listener.Close()
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	rr := httptest.NewRecorder()
+	assert.Error(t, tunnel(dst, rr, req))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestTrackInFlight(t *testing.T) {
+	dst := serversPool[0]
+	_, err := newPool("in-flight", PoolConfig{Servers: serversPool})
+	require.NoError(t, err)
+	counter, ok := inFlightCounter(dst)
+	require.True(t, ok)
+	before := counter.Load()
+
+	done := trackInFlight(dst)
+	assert.Equal(t, before+1, counter.Load())
+	done()
+	assert.Equal(t, before, counter.Load())
+
+	// Unknown backends are simply not tracked.
+	trackInFlight("unknown:8080")()
+}
+
+func TestReleaseInFlight(t *testing.T) {
+	const dst = "shared:8080"
+	p1, err := newPool("in-flight-1", PoolConfig{Servers: []string{dst}})
+	require.NoError(t, err)
+	p2, err := newPool("in-flight-2", PoolConfig{Servers: []string{dst}})
+	require.NoError(t, err)
+
+	// The counter stays while another pool still has the backend.
+	p1.setServers(nil)
+	_, ok := inFlightCounter(dst)
+	assert.True(t, ok)
+
+	p2.setServers(nil)
+	_, ok = inFlightCounter(dst)
+	assert.False(t, ok)
+	assert.NotContains(t, collectMetrics(nil).InFlight, dst)
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+const diskCheckSupported = false
+
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("disk space checks aren't supported on this platform")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Faults are injected into API responses to test how clients and the balancer handle misbehaving
+// servers. They are set at runtime through /debug/faults.
+type Faults struct {
+	Latency *LatencyFault `json:"latency,omitempty"`
+	Error   *ErrorFault   `json:"error,omitempty"`
+	// ResetRate is the share of requests whose connection is reset without a response.
+	ResetRate float64      `json:"resetRate,omitempty"`
+	Health    *HealthFault `json:"health,omitempty"`
+
+	// since is when the faults were set, the start of the first health flapping period.
+	since time.Time
+}
+
+// LatencyFault delays responses by a random duration.
+type LatencyFault struct {
+	// Distribution is "fixed" (Mean, the default), "uniform" (between Min and Max), "normal"
+	// (Mean and StdDev) or "exponential" (Mean).
+	Distribution string   `json:"distribution"`
+	Mean         Duration `json:"mean"`
+	StdDev       Duration `json:"stdDev"`
+	Min          Duration `json:"min"`
+	Max          Duration `json:"max"`
+	// Rate is the share of delayed requests, all of them when it's zero.
+	Rate float64 `json:"rate"`
+}
+
+// ErrorFault responds to a share of requests with an error status.
+type ErrorFault struct {
+	Rate float64 `json:"rate"`
+	// Status is 500 by default.
+	Status int `json:"status"`
+}
+
+// HealthFault overrides the readiness of the server with Status, "fail" by default. With a FlapPeriod
+// the server alternates between that status and its real one every period.
+type HealthFault struct {
+	Status     string   `json:"status"`
+	FlapPeriod Duration `json:"flapPeriod"`
+}
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (f *Faults) validate() error {
+	rates := []float64{f.ResetRate}
+	if l := f.Latency; l != nil {
+		switch l.Distribution {
+		case "", "fixed", "normal", "exponential":
+		case "uniform":
+			if l.Max < l.Min {
+				return fmt.Errorf("latency max is less than min")
+			}
+		default:
+			return fmt.Errorf("unknown latency distribution %q", l.Distribution)
+		}
+		if l.Mean < 0 || l.StdDev < 0 || l.Min < 0 {
+			return fmt.Errorf("latency can't be negative")
+		}
+		rates = append(rates, l.Rate)
+	}
+	if e := f.Error; e != nil {
+		if e.Status == 0 {
+			e.Status = http.StatusInternalServerError
+		}
+		if e.Status < 400 || e.Status > 599 {
+			return fmt.Errorf("error status %d isn't an error", e.Status)
+		}
+		rates = append(rates, e.Rate)
+	}
+	if h := f.Health; h != nil {
+		if h.Status == "" {
+			h.Status = statusFail
+		}
+		if _, ok := statusRanks[h.Status]; !ok {
+			return fmt.Errorf("unknown health status %q", h.Status)
+		}
+		if h.FlapPeriod < 0 {
+			return fmt.Errorf("flap period can't be negative")
+		}
+	}
+	for _, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("rate %g isn't between 0 and 1", rate)
+		}
+	}
+	return nil
+}
+
+// delay returns how long to delay a request.
+func (l *LatencyFault) delay() time.Duration {
+	if l.Rate > 0 && rand.Float64() >= l.Rate {
+		return 0
+	}
+	var d float64
+	switch l.Distribution {
+	case "uniform":
+		d = float64(l.Min) + rand.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		d = float64(l.Mean) + rand.NormFloat64()*float64(l.StdDev)
+	case "exponential":
+		d = rand.ExpFloat64() * float64(l.Mean)
+	default:
+		d = float64(l.Mean)
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+// faultInjector holds the current faults. Without faults requests go through untouched.
+type faultInjector struct {
+	faults atomic.Pointer[Faults]
+	now    func() time.Time
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{now: time.Now}
+}
+
+// ServeHTTP shows the faults on GET, replaces them with the JSON body on PUT or POST, and clears
+// them on DELETE.
+func (fi *faultInjector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		faults := new(Faults)
+		decoder := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(faults); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := faults.validate(); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		faults.since = fi.now()
+		fi.faults.Store(faults)
+	case http.MethodDelete:
+		fi.faults.Store(nil)
+	default:
+		rw.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	faults := fi.faults.Load()
+	if faults == nil {
+		faults = new(Faults)
+	}
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(faults)
+}
+
+// middleware injects the faults into the responses of the handler: the connection is reset, or the
+// response is delayed and then possibly replaced by an error.
+func (fi *faultInjector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		faults := fi.faults.Load()
+		if faults == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if faults.ResetRate > 0 && rand.Float64() < faults.ResetRate {
+			resetConnection(rw)
+			return
+		}
+		if faults.Latency != nil {
+			select {
+			case <-time.After(faults.Latency.delay()):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if e := faults.Error; e != nil && e.Rate > 0 && rand.Float64() < e.Rate {
+			http.Error(rw, "injected fault", e.Status)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// resetConnection closes the connection of an HTTP/1 request with a TCP reset. HTTP/2 streams are
+// reset instead, as their connection is shared with other requests.
+func resetConnection(rw http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// healthCheck reports the health status forced by the faults, flapping between it and ok.
+func (fi *faultInjector) healthCheck() CheckResult {
+	faults := fi.faults.Load()
+	if faults == nil || faults.Health == nil {
+		return CheckResult{Status: statusOK}
+	}
+	h := faults.Health
+	if h.FlapPeriod > 0 && int64(fi.now().Sub(faults.since)/time.Duration(h.FlapPeriod))%2 == 1 {
+		return CheckResult{Status: statusOK, Message: "flapping"}
+	}
+	return CheckResult{Status: h.Status, Message: "injected fault"}
+}
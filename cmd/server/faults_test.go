@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setFaults(t *testing.T, fi *faultInjector, body string) int {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	fi.ServeHTTP(rw, httptest.NewRequest(http.MethodPut, "/debug/faults", strings.NewReader(body)))
+	return rw.Code
+}
+
+func TestFaultsEndpoint(t *testing.T) {
+	fi := newFaultInjector()
+	if code := setFaults(t, fi, `{"error": {"rate": 0.5}, "latency": {"distribution": "uniform", "min": "1ms", "max": "3ms"}}`); code != http.StatusOK {
+		t.Fatalf("Setting faults gave %d", code)
+	}
+
+	rw := httptest.NewRecorder()
+	fi.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/debug/faults", nil))
+	var faults Faults
+	if err := json.NewDecoder(rw.Body).Decode(&faults); err != nil {
+		t.Fatal(err)
+	}
+	if faults.Error == nil || faults.Error.Status != http.StatusInternalServerError || faults.Latency.Max != Duration(3*time.Millisecond) {
+		t.Errorf("Unexpected faults %+v", faults)
+	}
+
+	for _, body := range []string{
+		`{"error": {"rate": 2}}`,
+		`{"error": {"rate": 1, "status": 200}}`,
+		`{"latency": {"distribution": "pareto"}}`,
+		`{"latency": {"distribution": "uniform", "min": "2s", "max": "1s"}}`,
+		`{"health": {"status": "sick"}}`,
+		`{"unknown": true}`,
+	} {
+		if code := setFaults(t, fi, body); code != http.StatusBadRequest {
+			t.Errorf("%s gave %d", body, code)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	fi.ServeHTTP(rw, httptest.NewRequest(http.MethodDelete, "/debug/faults", nil))
+	if fi.faults.Load() != nil {
+		t.Error("Faults weren't cleared")
+	}
+}
+
+func TestFaultMiddleware(t *testing.T) {
+	fi := newFaultInjector()
+	server := httptest.NewServer(fi.middleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	})))
+	defer server.Close()
+	get := func() (*http.Response, error) {
+		resp, err := http.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	if resp, err := get(); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Request without faults gave %v, %v", resp, err)
+	}
+
+	setFaults(t, fi, `{"error": {"rate": 1, "status": 503}}`)
+	if resp, err := get(); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Injected error gave %v, %v", resp, err)
+	}
+
+	setFaults(t, fi, `{"latency": {"mean": "50ms"}}`)
+	start := time.Now()
+	if _, err := get(); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Injected latency gave %s, %v", time.Since(start), err)
+	}
+
+	setFaults(t, fi, `{"resetRate": 1}`)
+	if _, err := get(); err == nil {
+		t.Error("Connection wasn't reset")
+	}
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	for _, l := range []LatencyFault{
+		{Mean: Duration(time.Second)},
+		{Distribution: "uniform", Min: Duration(time.Second), Max: Duration(2 * time.Second)},
+		{Distribution: "normal", Mean: Duration(time.Second), StdDev: Duration(100 * time.Millisecond)},
+		{Distribution: "exponential", Mean: Duration(time.Second)},
+	} {
+		var total time.Duration
+		const n = 1000
+		for range n {
+			d := l.delay()
+			if d < 0 || (l.Distribution == "uniform" && (d < time.Second || d > 2*time.Second)) {
+				t.Fatalf("%s delay %s is out of range", l.Distribution, d)
+			}
+			total += d
+		}
+		if mean := total / n; mean < 800*time.Millisecond || mean > 1700*time.Millisecond {
+			t.Errorf("%s mean delay is %s", l.Distribution, mean)
+		}
+	}
+
+	if d := (&LatencyFault{Mean: Duration(time.Second), Rate: 0.000001}).delay(); d != 0 {
+		t.Errorf("Rarely delayed request was delayed by %s", d)
+	}
+}
+
+func TestHealthFlapping(t *testing.T) {
+	fi := newFaultInjector()
+	now := time.Now()
+	fi.now = func() time.Time { return now }
+	if result := fi.healthCheck(); result.Status != statusOK {
+		t.Errorf("Health without faults is %s", result.Status)
+	}
+
+	setFaults(t, fi, `{"health": {"flapPeriod": "10s"}}`)
+	start := now
+	for _, tc := range []struct {
+		elapsed time.Duration
+		status  string
+	}{{0, statusFail}, {9 * time.Second, statusFail}, {10 * time.Second, statusOK}, {25 * time.Second, statusFail}} {
+		now = start.Add(tc.elapsed)
+		if result := fi.healthCheck(); result.Status != tc.status {
+			t.Errorf("Health after %s is %s, expected %s", tc.elapsed, result.Status, tc.status)
+		}
+	}
+
+	setFaults(t, fi, `{"health": {"status": "degraded"}}`)
+	if result := fi.healthCheck(); result.Status != statusDegraded {
+		t.Errorf("Forced health is %s", result.Status)
+	}
+}
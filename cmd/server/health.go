@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/roman-mazur/design-practice-2-template/datastore"
+)
+
+// Statuses of health checks, from best to worst. A degraded server still works but should get less
+// traffic; a failed one shouldn't get any.
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusFail     = "fail"
+)
+
+var statusRanks = map[string]int{statusOK: 0, statusDegraded: 1, statusFail: 2}
+
+// healthProbeKey is written to the datastore on startup and read back by the health check.
+const healthProbeKey = "health-probe"
+
+type CheckResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is the body of the health endpoints. Its status is the worst status of the checks.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// healthChecks are the named component checks of a health endpoint.
+type healthChecks map[string]func() CheckResult
+
+func (hc healthChecks) run() HealthReport {
+	report := HealthReport{Status: statusOK, Checks: make(map[string]CheckResult, len(hc))}
+	names := make([]string, 0, len(hc))
+	for name := range hc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result := hc[name]()
+		report.Checks[name] = result
+		if statusRanks[result.Status] > statusRanks[report.Status] {
+			report.Status = result.Status
+		}
+	}
+	return report
+}
+
+// ServeHTTP responds with 200 unless a check failed, so that degraded servers still pass plain status
+// code checks; clients that understand the report can tell them apart by the status field.
+func (hc healthChecks) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	report := hc.run()
+	code := http.StatusOK
+	if report.Status == statusFail {
+		code = http.StatusServiceUnavailable
+	}
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(code)
+	_ = json.NewEncoder(rw).Encode(report)
+}
+
+// configCheck reports the state forced with CONF_HEALTH_FAILURE: "true" fails the server and
+// "degraded" degrades it.
+func configCheck() CheckResult {
+	switch os.Getenv(confHealthFailure) {
+	case "true":
+		return CheckResult{Status: statusFail, Message: confHealthFailure + " is set"}
+	case statusDegraded:
+		return CheckResult{Status: statusDegraded, Message: confHealthFailure + " is degraded"}
+	}
+	return CheckResult{Status: statusOK}
+}
+
+// goroutineCheck degrades the server when it runs more goroutines than expected, usually a sign of
+// a leak or of requests piling up.
+func goroutineCheck(maxGoroutines int) func() CheckResult {
+	return func() CheckResult {
+		n := runtime.NumGoroutine()
+		result := CheckResult{Status: statusOK, Message: fmt.Sprintf("%d goroutines", n)}
+		if maxGoroutines > 0 && n > maxGoroutines {
+			result.Status = statusDegraded
+			result.Message += fmt.Sprintf(", more than %d", maxGoroutines)
+		}
+		return result
+	}
+}
+
+// diskCheck degrades the server when the disk of the directory has less free space than minFree bytes.
+func diskCheck(dir string, minFree uint64) func() CheckResult {
+	return func() CheckResult {
+		free, err := diskFree(dir)
+		if err != nil {
+			return CheckResult{Status: statusFail, Message: err.Error()}
+		}
+		result := CheckResult{Status: statusOK, Message: fmt.Sprintf("%d MiB free", free>>20)}
+		if free < minFree {
+			result.Status = statusDegraded
+			result.Message += fmt.Sprintf(", less than %d MiB", minFree>>20)
+		}
+		return result
+	}
+}
+
+// datastoreCheck fails the server when the probe written on startup can't be read back.
+func datastoreCheck(s *store) func() CheckResult {
+	return func() CheckResult {
+		if _, err := s.get(healthProbeKey); err != nil {
+			if errors.Is(err, datastore.ErrNotFound) {
+				return CheckResult{Status: statusFail, Message: "health probe is missing"}
+			}
+			return CheckResult{Status: statusFail, Message: err.Error()}
+		}
+		return CheckResult{Status: statusOK}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func serveHealth(t *testing.T, checks healthChecks) (int, HealthReport) {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	checks.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report HealthReport
+	if err := json.NewDecoder(rw.Body).Decode(&report); err != nil {
+		t.Fatalf("Invalid health report: %s", err)
+	}
+	return rw.Code, report
+}
+
+func TestHealthChecksStatus(t *testing.T) {
+	ok := func() CheckResult { return CheckResult{Status: statusOK} }
+	degraded := func() CheckResult { return CheckResult{Status: statusDegraded, Message: "slow"} }
+	fail := func() CheckResult { return CheckResult{Status: statusFail, Message: "down"} }
+
+	for _, tc := range []struct {
+		name   string
+		checks healthChecks
+		code   int
+		status string
+	}{
+		{"empty", healthChecks{}, http.StatusOK, statusOK},
+		{"ok", healthChecks{"a": ok, "b": ok}, http.StatusOK, statusOK},
+		{"degraded", healthChecks{"a": ok, "b": degraded}, http.StatusOK, statusDegraded},
+		{"fail", healthChecks{"a": fail, "b": degraded}, http.StatusServiceUnavailable, statusFail},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, report := serveHealth(t, tc.checks)
+			if code != tc.code || report.Status != tc.status {
+				t.Errorf("Got %d %s, expected %d %s", code, report.Status, tc.code, tc.status)
+			}
+			if len(report.Checks) != len(tc.checks) {
+				t.Errorf("Unexpected checks %v", report.Checks)
+			}
+		})
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	for value, status := range map[string]string{"": statusOK, "true": statusFail, "degraded": statusDegraded} {
+		t.Setenv(confHealthFailure, value)
+		if result := configCheck(); result.Status != status {
+			t.Errorf("%s=%q gave %s, expected %s", confHealthFailure, value, result.Status, status)
+		}
+	}
+}
+
+func TestGoroutineCheck(t *testing.T) {
+	if result := goroutineCheck(0)(); result.Status != statusOK {
+		t.Errorf("Unlimited goroutines gave %+v", result)
+	}
+	if result := goroutineCheck(1)(); result.Status != statusDegraded {
+		t.Errorf("Too many goroutines gave %+v", result)
+	}
+}
+
+func TestDiskCheck(t *testing.T) {
+	if !diskCheckSupported {
+		t.Skip("disk checks aren't supported")
+	}
+	dir := t.TempDir()
+	if result := diskCheck(dir, 0)(); result.Status != statusOK {
+		t.Errorf("Disk check gave %+v", result)
+	}
+	if result := diskCheck(dir, math.MaxUint64)(); result.Status != statusDegraded {
+		t.Errorf("Full disk gave %+v", result)
+	}
+	if result := diskCheck(filepath.Join(dir, "missing"), 0)(); result.Status != statusFail {
+		t.Errorf("Missing dir gave %+v", result)
+	}
+}
+
+func TestDatastoreCheck(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := datastoreCheck(s)
+	if result := check(); result.Status != statusOK {
+		t.Errorf("Datastore check gave %+v", result)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if result := check(); result.Status != statusFail {
+		t.Errorf("Removed datastore gave %+v", result)
+	}
+}
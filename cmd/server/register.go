@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const registrationRetryInterval = 2 * time.Second
+
+// registrar keeps the server registered with the balancer by sending heartbeats
+// a few times per registration TTL.
+type registrar struct {
+	url    string
+	token  string
+	body   []byte
+	client *http.Client
+
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func startRegistration(balancerURL, token, pool, address string) *registrar {
+	body, _ := json.Marshal(map[string]string{"pool": pool, "address": address})
+	rg := &registrar{
+		url:     strings.TrimSuffix(balancerURL, "/") + "/register",
+		token:   token,
+		body:    body,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go rg.run()
+	return rg
+}
+
+func (rg *registrar) run() {
+	defer close(rg.done)
+	for {
+		interval := registrationRetryInterval
+		ttl, err := rg.heartbeat()
+		if err != nil {
+			log.Printf("Failed to register with the balancer: %s", err)
+		} else if ttl > 0 {
+			interval = ttl / 3
+		}
+		select {
+		case <-rg.stopped:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// heartbeat registers the server, or renews its registration, and returns how long it lasts.
+func (rg *registrar) heartbeat() (time.Duration, error) {
+	resp, err := rg.send(http.MethodPost)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var registration struct {
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registration); err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(registration.TTL)
+}
+
+// stop ends the heartbeats and deregisters the server, so the balancer stops sending it requests
+// right away instead of waiting for the registration to expire.
+func (rg *registrar) stop() {
+	close(rg.stopped)
+	<-rg.done
+	resp, err := rg.send(http.MethodDelete)
+	if err != nil {
+		log.Printf("Failed to deregister from the balancer: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (rg *registrar) send(method string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rg.url, bytes.NewReader(rg.body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+rg.token)
+	return rg.client.Do(req)
+}
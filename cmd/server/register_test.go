@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistrar(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	balancer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/register" || r.Header.Get("Authorization") != "Bearer secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["pool"] != "api" || body["address"] != ":8080" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method == http.MethodPost {
+			_, _ = rw.Write([]byte(`{"ttl":"150ms"}`))
+		}
+	}))
+	defer balancer.Close()
+
+	rg := startRegistration(balancer.URL+"/", "secret", "api", ":8080")
+	time.Sleep(220 * time.Millisecond)
+	rg.stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Heartbeats are sent every third of the TTL.
+	if len(methods) < 3 {
+		t.Fatalf("Expected at least 3 requests, got %v", methods)
+	}
+	for _, method := range methods[:len(methods)-1] {
+		if method != http.MethodPost {
+			t.Errorf("Unexpected heartbeat method %s", method)
+		}
+	}
+	if methods[len(methods)-1] != http.MethodDelete {
+		t.Errorf("Expected deregistration, got %v", methods)
+	}
+}
@@ -2,31 +2,231 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/design-practice-2-template/datastore"
 )
 
 const reportMaxLen = 100
 
-type Report map[string][]string
+// Keys of the report in the datastore: the list of authors and the entry of each author.
+const (
+	reportAuthorsKey   = "report/authors"
+	reportAuthorPrefix = "report/author/"
+)
+
+// AuthorReport describes the requests of one author: how many there were, when the first and the
+// last ones arrived, and the counters of the last reportMaxLen of them.
+type AuthorReport struct {
+	Count    int64     `json:"count"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Requests []string  `json:"requests"`
+}
+
+// Report collects the requests received from each author. It's safe for concurrent use and, with
+// a store, keeps its entries across restarts.
+type Report struct {
+	now   func() time.Time
+	store *store
+
+	mu      sync.Mutex
+	authors map[string]*AuthorReport
+	// dirty authors were changed since the report was last saved, and dirtyList tells that authors
+	// were added or removed.
+	dirty     map[string]bool
+	dirtyList bool
+}
+
+// newReport creates a report, loading its entries from the store if it isn't nil.
+func newReport(s *store) (*Report, error) {
+	r := &Report{
+		now:     time.Now,
+		store:   s,
+		authors: make(map[string]*AuthorReport),
+		dirty:   make(map[string]bool),
+	}
+	if s == nil {
+		return r, nil
+	}
+
+	value, err := s.get(reportAuthorsKey)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var authors []string
+	if err := json.Unmarshal([]byte(value), &authors); err != nil {
+		return nil, err
+	}
+	for _, author := range authors {
+		value, err := s.get(reportAuthorPrefix + author)
+		if err != nil {
+			return nil, err
+		}
+		entry := new(AuthorReport)
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			return nil, err
+		}
+		r.authors[author] = entry
+	}
+	return r, nil
+}
 
-func (r Report) Process(req *http.Request) {
+func (r *Report) Process(req *http.Request) {
 	author := req.Header.Get("lb-author")
 	counter := req.Header.Get("lb-req-cnt")
 	log.Printf("GET some-data from [%s] request [%s]", author, counter)
 
 	if len(author) > 0 {
-		list := r[author]
-		list = append(list, counter)
-		if len(list) > reportMaxLen {
-			list = list[len(list)-reportMaxLen:]
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		now := r.now()
+		entry, ok := r.authors[author]
+		if !ok {
+			entry = &AuthorReport{First: now}
+			r.authors[author] = entry
+			r.dirtyList = true
+		}
+		entry.Count++
+		entry.Last = now
+		entry.Requests = append(entry.Requests, counter)
+		if len(entry.Requests) > reportMaxLen {
+			entry.Requests = entry.Requests[len(entry.Requests)-reportMaxLen:]
+		}
+		r.dirty[author] = true
+	}
+}
+
+// get returns a copy of the entries of the authors, or of all of them without authors.
+func (r *Report) get(authors []string) map[string]AuthorReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make(map[string]AuthorReport)
+	add := func(author string, entry *AuthorReport) {
+		copied := *entry
+		copied.Requests = append([]string(nil), entry.Requests...)
+		result[author] = copied
+	}
+	if len(authors) == 0 {
+		for author, entry := range r.authors {
+			add(author, entry)
+		}
+	}
+	for _, author := range authors {
+		if entry, ok := r.authors[author]; ok {
+			add(author, entry)
 		}
-		r[author] = list
 	}
+	return result
+}
+
+func (r *Report) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authors = make(map[string]*AuthorReport)
+	r.dirty = make(map[string]bool)
+	r.dirtyList = true
 }
 
-func (r Report) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+// ServeHTTP responds with the report, only for the authors given with ?author= if any, and clears it on DELETE.
+func (r *Report) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodDelete:
+		r.clear()
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, DELETE")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
 	rw.Header().Set("content-type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(rw).Encode(r)
+	_ = json.NewEncoder(rw).Encode(r.get(req.URL.Query()["author"]))
+}
+
+// save writes the entries changed since the last save to the store. They are copied under the lock
+// and written after releasing it, so requests don't wait for the disk. Authors are written before
+// the list referencing them, so the store is consistent if the server stops in between, and those
+// that couldn't be written are saved again next time.
+func (r *Report) save() error {
+	if r.store == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	entries := make(map[string]string, len(r.dirty))
+	for author := range r.dirty {
+		value, err := json.Marshal(r.authors[author])
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		entries[author] = string(value)
+	}
+	r.dirty = make(map[string]bool)
+	var list []byte
+	if r.dirtyList {
+		authors := make([]string, 0, len(r.authors))
+		for author := range r.authors {
+			authors = append(authors, author)
+		}
+		list, _ = json.Marshal(authors)
+		r.dirtyList = false
+	}
+	r.mu.Unlock()
+
+	err := r.write(entries, list)
+	if err != nil {
+		r.mu.Lock()
+		for author := range entries {
+			if _, ok := r.authors[author]; ok {
+				r.dirty[author] = true
+			}
+		}
+		r.dirtyList = r.dirtyList || list != nil
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// write puts the entries, and the list of authors if it isn't nil, to the store. Entries written
+// successfully are removed from the map.
+func (r *Report) write(entries map[string]string, list []byte) error {
+	for author, value := range entries {
+		if err := r.store.put(reportAuthorPrefix+author, value); err != nil {
+			return err
+		}
+		delete(entries, author)
+	}
+	if list != nil {
+		return r.store.put(reportAuthorsKey, string(list))
+	}
+	return nil
+}
+
+// persist saves the report every interval until stop is closed, and once more before returning.
+func (r *Report) persist(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for stopped := false; !stopped; {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			stopped = true
+		}
+		if err := r.save(); err != nil {
+			log.Printf("Failed to save the report: %s", err)
+		}
+	}
 }
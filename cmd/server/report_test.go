@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestReport_Process(t *testing.T) {
@@ -11,17 +16,17 @@ func TestReport_Process(t *testing.T) {
 	req.Header.Set("lb-author", "test-author")
 	req.Header.Set("lb-req-cnt", "1")
 
-	r := make(Report)
+	r, _ := newReport(nil)
 
 	r.Process(req)
-	if !reflect.DeepEqual(r["test-author"], []string{"1"}) {
-		t.Errorf("Unexpected report state %s", r)
+	if !reflect.DeepEqual(r.authors["test-author"].Requests, []string{"1"}) {
+		t.Errorf("Unexpected report state %v", r.get(nil))
 	}
 
 	req.Header.Set("lb-req-cnt", "2")
 	r.Process(req)
-	if !reflect.DeepEqual(r["test-author"], []string{"1", "2"}) {
-		t.Errorf("Unexpected report state %s", r)
+	if !reflect.DeepEqual(r.authors["test-author"].Requests, []string{"1", "2"}) {
+		t.Errorf("Unexpected report state %v", r.get(nil))
 	}
 
 	req.Header.Set("lb-author", "test-len")
@@ -29,7 +34,143 @@ func TestReport_Process(t *testing.T) {
 		req.Header.Set("lb-req-cnt", "test-len")
 		r.Process(req)
 	}
-	if len(r["test-len"]) != reportMaxLen {
-		t.Errorf("Unexpectd error length: %d", len(r["test-len"]))
+	if len(r.authors["test-len"].Requests) != reportMaxLen {
+		t.Errorf("Unexpectd error length: %d", len(r.authors["test-len"].Requests))
+	}
+	if r.authors["test-len"].Count != 103 {
+		t.Errorf("Unexpected count: %d", r.authors["test-len"].Count)
+	}
+}
+
+func TestReport_Timestamps(t *testing.T) {
+	r, _ := newReport(nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	r.now = func() time.Time { return now }
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("lb-author", "clock")
+	r.Process(req)
+	now = now.Add(time.Minute)
+	r.Process(req)
+
+	entry := r.get(nil)["clock"]
+	if !entry.First.Equal(start) || !entry.Last.Equal(now) || entry.Count != 2 {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+}
+
+func TestReport_ServeHTTP(t *testing.T) {
+	r, _ := newReport(nil)
+	for _, author := range []string{"a", "b", "c"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", author)
+		r.Process(req)
+	}
+
+	get := func(url string) map[string]AuthorReport {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, url, nil))
+		var data map[string]AuthorReport
+		if err := json.NewDecoder(rw.Body).Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if data := get("/report"); len(data) != 3 {
+		t.Errorf("Unexpected report %v", data)
+	}
+	if data := get("/report?author=a&author=c&author=missing"); len(data) != 2 || data["a"].Count != 1 || data["c"].Count != 1 {
+		t.Errorf("Unexpected filtered report %v", data)
+	}
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodDelete, "/report", nil))
+	if rw.Code != http.StatusNoContent {
+		t.Errorf("DELETE gave %d", rw.Code)
+	}
+	if data := get("/report"); len(data) != 0 {
+		t.Errorf("Report wasn't reset: %v", data)
+	}
+}
+
+func TestReport_Concurrency(t *testing.T) {
+	r, _ := newReport(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("lb-author", fmt.Sprintf("author-%d", i%2))
+			for j := 0; j < 100; j++ {
+				req.Header.Set("lb-req-cnt", fmt.Sprint(j))
+				r.Process(req)
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report", nil))
+			}
+		}()
+	}
+	wg.Wait()
+	if data := r.get(nil); data["author-0"].Count != 400 || data["author-1"].Count != 400 {
+		t.Errorf("Unexpected counts %d, %d", data["author-0"].Count, data["author-1"].Count)
+	}
+}
+
+func TestReport_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newReport(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	for i, author := range []string{"a", "b", "a"} {
+		req.Header.Set("lb-author", author)
+		req.Header.Set("lb-req-cnt", fmt.Sprint(i))
+		r.Process(req)
+	}
+	stop := make(chan struct{})
+	close(stop)
+	r.persist(time.Hour, stop)
+
+	reopen := func() map[string]AuthorReport {
+		t.Helper()
+		s, err := openStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := newReport(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loaded.get(nil)
+	}
+	if data := reopen(); len(data) != 2 || !reflect.DeepEqual(data["a"].Requests, []string{"0", "2"}) {
+		t.Errorf("Unexpected loaded report %v", data)
+	}
+
+	r.clear()
+	req.Header.Set("lb-author", "c")
+	r.Process(req)
+	if err := r.save(); err != nil {
+		t.Fatal(err)
+	}
+	if data := reopen(); len(data) != 1 || data["c"].Count != 1 {
+		t.Errorf("Unexpected report after reset %v", data)
+	}
+
+	// Entries that couldn't be written are kept for the next save.
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	r.Process(req)
+	if err := r.save(); err == nil {
+		t.Error("Expected an error saving to a closed store")
+	}
+	if !r.dirty["c"] {
+		t.Error("Unsaved entry is no longer dirty")
 	}
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,28 +14,68 @@ import (
 	"github.com/roman-mazur/design-practice-2-template/signal"
 )
 
-var port = flag.Int("port", 8080, "server port")
+var (
+	port = flag.Int("port", 8080, "server port")
+	h2c  = flag.Bool("h2c", false, "whether to accept cleartext HTTP/2")
+
+	registerURL     = flag.String("register", "", "admin URL of the balancer to register with, e.g. http://balancer:8091")
+	registerPool    = flag.String("register-pool", "default", "balancer pool to join")
+	registerAddress = flag.String("register-address", "", "address the balancer should use to reach this server (:port by default)")
+	registerToken   = flag.String("register-token", os.Getenv("LB_REGISTER_TOKEN"), "shared token accepted by the balancer")
+
+	dataDir       = flag.String("data-dir", "", "directory of the datastore keeping the report, checked for readiness when set")
+	minFreeDiskMB = flag.Uint64("min-free-disk-mb", 100, "free space of the data dir below which the server is degraded")
+	maxGoroutines = flag.Int("max-goroutines", 10000, "number of goroutines above which the server is degraded")
+
+	debugFaults = flag.Bool("debug-faults", false, "whether to accept fault injection through /debug/faults")
+)
+
+// reportSaveInterval is long, as every save appends the changed entries to the datastore. The report
+// is also saved on shutdown.
+const reportSaveInterval = time.Minute
 
 const confResponseDelaySec = "CONF_RESPONSE_DELAY_SEC"
 const confHealthFailure = "CONF_HEALTH_FAILURE"
 
 func main() {
+	flag.Parse()
 	h := new(http.ServeMux)
 
-	h.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("content-type", "text/plain")
-		if failConfig := os.Getenv(confHealthFailure); failConfig == "true" {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = rw.Write([]byte("FAILURE"))
-		} else {
-			rw.WriteHeader(http.StatusOK)
-			_, _ = rw.Write([]byte("OK"))
+	// Liveness only covers the process itself, readiness also its dependencies. /health is kept
+	// for the balancer and reports readiness.
+	liveness := healthChecks{"goroutines": goroutineCheck(*maxGoroutines)}
+	readiness := healthChecks{"config": configCheck, "goroutines": goroutineCheck(*maxGoroutines)}
+	var db *store
+	if *dataDir != "" {
+		var err error
+		if db, err = openStore(*dataDir); err != nil {
+			log.Fatalf("Failed to open the datastore: %s", err)
 		}
-	})
+		readiness["datastore"] = datastoreCheck(db)
+		if diskCheckSupported {
+			readiness["disk"] = diskCheck(*dataDir, *minFreeDiskMB<<20)
+		}
+	}
+	faults := newFaultInjector()
+	if *debugFaults {
+		h.Handle("/debug/faults", faults)
+		readiness["faults"] = faults.healthCheck
+	}
+	h.Handle("/healthz", liveness)
+	h.Handle("/readyz", readiness)
+	h.Handle("/health", readiness)
 
-	report := make(Report)
+	report, err := newReport(db)
+	if err != nil {
+		log.Fatalf("Failed to load the report: %s", err)
+	}
+	stopSaving, saved := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(saved)
+		report.persist(reportSaveInterval, stopSaving)
+	}()
 
-	h.HandleFunc("/api/v1/some-data", func(rw http.ResponseWriter, r *http.Request) {
+	h.Handle("/api/v1/some-data", faults.middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		respDelayString := os.Getenv(confResponseDelaySec)
 		if delaySec, parseErr := strconv.Atoi(respDelayString); parseErr == nil && delaySec > 0 && delaySec < 300 {
 			time.Sleep(time.Duration(delaySec) * time.Second)
@@ -46,11 +88,30 @@ func main() {
 		_ = json.NewEncoder(rw).Encode([]string{
 			"1", "2",
 		})
-	})
+	})))
 
 	h.Handle("/report", report)
 
-	server := httptools.CreateServer(*port, h)
+	server := httptools.CreateServerWithOptions(*port, h, httptools.Options{H2C: *h2c})
 	server.Start()
+
+	var registration *registrar
+	if *registerURL != "" {
+		address := *registerAddress
+		if address == "" {
+			address = fmt.Sprintf(":%d", *port)
+		}
+		registration = startRegistration(*registerURL, *registerToken, *registerPool, address)
+	}
 	signal.WaitForTerminationSignal()
+	if registration != nil {
+		registration.stop()
+	}
+	close(stopSaving)
+	<-saved
+	if db != nil {
+		if err := db.close(); err != nil {
+			log.Printf("Failed to close the datastore: %s", err)
+		}
+	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/roman-mazur/design-practice-2-template/datastore"
+)
+
+// store guards the datastore, which isn't safe for concurrent use.
+type store struct {
+	mu sync.Mutex
+	db *datastore.Db
+}
+
+func openStore(dir string) (*store, error) {
+	db, err := datastore.NewDb(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{db: db}
+	if err := s.put(healthProbeKey, "ok"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *store) get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Get(key)
+}
+
+func (s *store) put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Put(key, value)
+}
+
+func (s *store) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// authorReport is an entry of the /report of a server.
+type authorReport struct {
+	Count    int64    `json:"count"`
+	Requests []string `json:"requests"`
+}
+
+type report map[string]authorReport
+
+type BackendStats struct {
+	Address  string  `json:"address"`
+	Requests int64   `json:"requests"`
+	Share    float64 `json:"share"`
+	Authors  int     `json:"authors"`
+	// Error tells why the report of the backend couldn't be fetched. Such backends are left out
+	// of the totals.
+	Error string `json:"error,omitempty"`
+}
+
+// AuthorStats tells how the requests of an author spread over backends. Stickiness is the share
+// of them handled by the primary backend, the one that got most, so 1 means all went to one backend.
+type AuthorStats struct {
+	Author     string  `json:"author"`
+	Requests   int64   `json:"requests"`
+	Backends   int     `json:"backends"`
+	Primary    string  `json:"primary"`
+	Stickiness float64 `json:"stickiness"`
+}
+
+// Analysis describes how requests were distributed across backends. Imbalance is the ratio of the
+// busiest backend's requests to the mean, 1 for a perfectly even distribution, and CV is the
+// coefficient of variation of the requests per backend.
+type Analysis struct {
+	Backends       []BackendStats `json:"backends"`
+	Total          int64          `json:"total"`
+	Imbalance      float64        `json:"imbalance"`
+	CV             float64        `json:"cv"`
+	Authors        []AuthorStats  `json:"authors"`
+	StickyAuthors  int            `json:"stickyAuthors"`
+	MeanStickiness float64        `json:"meanStickiness"`
+}
+
+// analyze computes the distribution of requests from the reports of the servers, in their order.
+// Servers missing from reports failed with the error in errs.
+func analyze(servers []string, reports map[string]report, errs map[string]error) Analysis {
+	var a Analysis
+	perAuthor := make(map[string]map[string]int64)
+	for _, server := range servers {
+		b := BackendStats{Address: server}
+		if err, failed := errs[server]; failed {
+			b.Error = err.Error()
+			a.Backends = append(a.Backends, b)
+			continue
+		}
+		for author, entry := range reports[server] {
+			b.Requests += entry.Count
+			b.Authors++
+			if perAuthor[author] == nil {
+				perAuthor[author] = make(map[string]int64)
+			}
+			perAuthor[author][server] += entry.Count
+		}
+		a.Total += b.Requests
+		a.Backends = append(a.Backends, b)
+	}
+
+	var counts []float64
+	for i := range a.Backends {
+		b := &a.Backends[i]
+		if b.Error != "" {
+			continue
+		}
+		if a.Total > 0 {
+			b.Share = float64(b.Requests) / float64(a.Total)
+		}
+		counts = append(counts, float64(b.Requests))
+	}
+	a.Imbalance, a.CV = imbalance(counts)
+
+	for author, backends := range perAuthor {
+		s := AuthorStats{Author: author, Backends: len(backends)}
+		var primary int64
+		for server, n := range backends {
+			s.Requests += n
+			if n > primary || (n == primary && server < s.Primary) {
+				primary, s.Primary = n, server
+			}
+		}
+		if s.Requests > 0 {
+			s.Stickiness = float64(primary) / float64(s.Requests)
+		}
+		if s.Backends == 1 {
+			a.StickyAuthors++
+		}
+		a.MeanStickiness += s.Stickiness
+		a.Authors = append(a.Authors, s)
+	}
+	if len(a.Authors) > 0 {
+		a.MeanStickiness /= float64(len(a.Authors))
+	}
+	sort.Slice(a.Authors, func(i, j int) bool {
+		if a.Authors[i].Requests != a.Authors[j].Requests {
+			return a.Authors[i].Requests > a.Authors[j].Requests
+		}
+		return a.Authors[i].Author < a.Authors[j].Author
+	})
+	return a
+}
+
+// imbalance returns the max to mean ratio and the coefficient of variation of the counts, both zero
+// without requests.
+func imbalance(counts []float64) (float64, float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+	var sum, highest float64
+	for _, c := range counts {
+		sum += c
+		highest = math.Max(highest, c)
+	}
+	if sum == 0 {
+		return 0, 0
+	}
+	mean := sum / float64(len(counts))
+	var variance float64
+	for _, c := range counts {
+		variance += (c - mean) * (c - mean)
+	}
+	variance /= float64(len(counts))
+	return highest / mean, math.Sqrt(variance) / mean
+}
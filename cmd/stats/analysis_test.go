@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	reports := map[string]report{
+		"s1": {"a": {Count: 30}, "b": {Count: 10}},
+		"s2": {"b": {Count: 30}, "c": {Count: 10}},
+		"s3": {"d": {Count: 20}},
+	}
+	a := analyze([]string{"s1", "s2", "s3", "s4"}, reports, map[string]error{"s4": errors.New("connection refused")})
+
+	if a.Total != 100 || len(a.Backends) != 4 {
+		t.Fatalf("Unexpected analysis %+v", a)
+	}
+	expectedShares := []float64{0.4, 0.4, 0.2, 0}
+	for i, b := range a.Backends {
+		if math.Abs(b.Share-expectedShares[i]) > 1e-9 {
+			t.Errorf("%s share is %g, expected %g", b.Address, b.Share, expectedShares[i])
+		}
+	}
+	if a.Backends[3].Error != "connection refused" {
+		t.Errorf("Unexpected error %q", a.Backends[3].Error)
+	}
+	// Mean of 40, 40, 20 is 33.3; the unreachable backend is left out.
+	if math.Abs(a.Imbalance-1.2) > 1e-9 || math.Abs(a.CV-math.Sqrt2/5) > 1e-9 {
+		t.Errorf("Unexpected imbalance %g, cv %g", a.Imbalance, a.CV)
+	}
+
+	authors := make(map[string]AuthorStats)
+	for _, s := range a.Authors {
+		authors[s.Author] = s
+	}
+	if b := authors["b"]; b.Requests != 40 || b.Backends != 2 || b.Primary != "s2" || b.Stickiness != 0.75 {
+		t.Errorf("Unexpected stats of b %+v", b)
+	}
+	if a.Authors[0].Author != "b" || a.StickyAuthors != 3 || math.Abs(a.MeanStickiness-(3.75/4)) > 1e-9 {
+		t.Errorf("Unexpected authors %+v", a.Authors)
+	}
+}
+
+func TestImbalance(t *testing.T) {
+	if i, cv := imbalance([]float64{10, 10, 10}); i != 1 || cv != 0 {
+		t.Errorf("Even distribution gave %g, %g", i, cv)
+	}
+	if i, cv := imbalance([]float64{0, 0}); i != 0 || cv != 0 {
+		t.Errorf("No requests gave %g, %g", i, cv)
+	}
+	if i, _ := imbalance([]float64{30, 0, 0}); i != 3 {
+		t.Errorf("Single busy backend gave %g", i)
+	}
+}
+
+func TestPrintAnalysis(t *testing.T) {
+	a := analyze([]string{"s1", "s2"}, map[string]report{"s1": {"a": {Count: 3}}, "s2": {"a": {Count: 1}}}, nil)
+
+	var out bytes.Buffer
+	if err := printAnalysis(&out, a, "table"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"BACKEND", "s1  ", "75.0%", "imbalance 1.50", "STICKINESS", "0 of 1 authors"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Table misses %q:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := printAnalysis(&out, a, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Analysis
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, a) {
+		t.Errorf("JSON output %s doesn't match %+v", out.String(), a)
+	}
+
+	if err := printAnalysis(&out, a, "xml"); err == nil {
+		t.Error("Unknown format was accepted")
+	}
+}
+
+func TestConfigServers(t *testing.T) {
+	config := []byte(`{"pools": {"api": {"servers": ["b:80", "a:80"]}, "static": {"servers": ["a:80", "c:80"]}}}`)
+	if list, err := configServers(config, ""); err != nil || !reflect.DeepEqual(list, []string{"a:80", "b:80", "c:80"}) {
+		t.Errorf("All pools gave %v, %v", list, err)
+	}
+	if list, err := configServers(config, "api"); err != nil || !reflect.DeepEqual(list, []string{"b:80", "a:80"}) {
+		t.Errorf("Pool gave %v, %v", list, err)
+	}
+	if _, err := configServers(config, "missing"); err == nil {
+		t.Error("Missing pool was accepted")
+	}
+}
+
+func TestFetchReports(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte(`{"a": {"count": 5, "requests": ["1"]}}`))
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	up, missing := strings.TrimPrefix(server.URL, "http://"), strings.TrimPrefix(down.URL, "http://")
+	reports, errs := fetchReports(server.Client(), []string{up, missing})
+	if reports[up]["a"].Count != 5 || errs[missing] == nil || len(reports) != 1 {
+		t.Errorf("Unexpected reports %v and errors %v", reports, errs)
+	}
+}
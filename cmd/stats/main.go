@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 )
 
-var https = flag.Bool("https", false, "whether backends support HTTPs")
+var (
+	https      = flag.Bool("https", false, "whether backends support HTTPs")
+	servers    = flag.String("servers", strings.Join(serversPool, ","), "comma-separated backends to fetch reports from")
+	configPath = flag.String("config", "", "balancer config to read the backends from instead of -servers")
+	poolName   = flag.String("pool", "", "pool of the balancer config to analyse, all of them when empty")
+	format     = flag.String("format", "table", "output format: table or json")
+
+	watchMode = flag.Bool("watch", false, "whether to show a live dashboard of the balancer's backends")
+	admin     = flag.String("admin", "http://localhost:8091", "admin URL of the balancer watched with -watch")
+	interval  = flag.Duration("interval", 2*time.Second, "refresh interval of the dashboard")
+)
 
 var serversPool = []string{
 	"localhost:8080",
@@ -17,8 +33,6 @@ var serversPool = []string{
 	"localhost:8082",
 }
 
-type report map[string][]string
-
 func scheme() string {
 	if *https {
 		return "https"
@@ -26,37 +40,114 @@ func scheme() string {
 	return "http"
 }
 
-func main()  {
+func main() {
 	flag.Parse()
 
+	if *watchMode {
+		if *interval <= 0 {
+			log.Fatalf("-interval must be positive, got %s", *interval)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		d := &dashboard{admin: *admin, client: &http.Client{Timeout: *interval}, terminal: isTerminal(os.Stdout)}
+		watch(ctx, d, os.Stdout, *interval)
+		return
+	}
+
+	backends, err := backendList()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	client := new(http.Client)
 	client.Timeout = 10 * time.Second
 
-	res := make([]report, len(serversPool))
-	for i, s := range serversPool {
-		resp, err := client.Get(fmt.Sprintf("%s://%s/report", scheme(), s))
-		if err == nil {
-			var data report
-			if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-				//log.Printf("error parsing froom %s: %s", s, err)
-			} else {
-				for k, v := range data {
-					l := len(v)
-					if l > 5 {
-						l = 5
-					}
-					data[k] = v[len(v)-l:]
-				}
-				res[i] = data
+	reports, errs := fetchReports(client, backends)
+	for server, err := range errs {
+		log.Printf("error %s %s", server, err)
+	}
+	if err := printAnalysis(os.Stdout, analyze(backends, reports, errs), *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// backendList returns the backends given with -servers, or those of the balancer config.
+func backendList() ([]string, error) {
+	if *configPath == "" {
+		var list []string
+		for _, server := range strings.Split(*servers, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				list = append(list, server)
+			}
+		}
+		return list, nil
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	return configServers(data, *poolName)
+}
+
+// configServers reads the servers of a pool, or of all pools, from a balancer config.
+func configServers(data []byte, pool string) ([]string, error) {
+	var cfg struct {
+		Pools map[string]struct {
+			Servers []string `json:"servers"`
+		} `json:"pools"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if pool != "" {
+		p, ok := cfg.Pools[pool]
+		if !ok {
+			return nil, fmt.Errorf("no pool %q in the config", pool)
+		}
+		return p.Servers, nil
+	}
+
+	seen := make(map[string]bool)
+	var list []string
+	for _, p := range cfg.Pools {
+		for _, server := range p.Servers {
+			if !seen[server] {
+				seen[server] = true
+				list = append(list, server)
 			}
-		} else {
-			log.Printf("error %s %s", s, err)
 		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func fetchReports(client *http.Client, backends []string) (map[string]report, map[string]error) {
+	reports := make(map[string]report)
+	errs := make(map[string]error)
+	for _, s := range backends {
+		data, err := fetchReport(client, s)
+		if err != nil {
+			errs[s] = err
+			continue
+		}
+		reports[s] = data
+	}
+	return reports, errs
+}
 
-		log.Println("=========================")
-		log.Println("SERVER", i, serversPool[i])
-		log.Println("=========================")
-		data, _ := json.MarshalIndent(res[i], "", "  ")
-		log.Println(string(data))
+func fetchReport(client *http.Client, server string) (report, error) {
+	resp, err := client.Get(fmt.Sprintf("%s://%s/report", scheme(), server))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var data report
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("error parsing from %s: %w", server, err)
 	}
+	return data, nil
 }
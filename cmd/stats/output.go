@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+func printAnalysis(w io.Writer, a Analysis, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(a)
+	case "table":
+		printTable(w, a)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func printTable(w io.Writer, a Analysis) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKEND\tREQUESTS\tSHARE\tAUTHORS")
+	for _, b := range a.Backends {
+		if b.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t%s\n", b.Address, b.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%d\n", b.Address, b.Requests, 100*b.Share, b.Authors)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "\n%d requests, imbalance %.2f (max/mean), cv %.2f\n", a.Total, a.Imbalance, a.CV)
+	if len(a.Authors) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AUTHOR\tREQUESTS\tBACKENDS\tSTICKINESS\tPRIMARY")
+	for _, s := range a.Authors {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%s\n", s.Author, s.Requests, s.Backends, 100*s.Stickiness, s.Primary)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "\n%d of %d authors stuck to one backend, mean stickiness %.1f%%\n",
+		a.StickyAuthors, len(a.Authors), 100*a.MeanStickiness)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// clearScreen moves the cursor home and clears the terminal, so each frame replaces the previous one.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// balancerMetrics is the part of the balancer's /metrics shown by the dashboard.
+type balancerMetrics struct {
+	InFlight map[string]int64                     `json:"inFlight"`
+	Backends map[string]map[string]backendMetrics `json:"backends"`
+}
+
+type backendMetrics struct {
+	State      string `json:"state"`
+	Reason     string `json:"reason"`
+	Requests   int64  `json:"requests"`
+	Errors     int64  `json:"errors"`
+	LatencyP50 string `json:"latencyP50"`
+	LatencyP99 string `json:"latencyP99"`
+}
+
+// dashboard renders frames of backend metrics. Rates are computed from the counters of the previous frame.
+type dashboard struct {
+	admin    string
+	client   *http.Client
+	terminal bool
+	prev     *balancerMetrics
+	prevAt   time.Time
+}
+
+// isTerminal tells whether the file is a character device, which is how a terminal looks without
+// platform specific calls.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// watch polls the balancer every interval and writes frames until the context is done. On a terminal
+// each frame replaces the previous one; otherwise frames are appended with a timestamp, e.g. to a log.
+func watch(ctx context.Context, d *dashboard, w io.Writer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m, err := d.fetch()
+		now := time.Now()
+		var frame strings.Builder
+		if err != nil {
+			fmt.Fprintf(&frame, "%s  error: %s\n", now.Format(time.TimeOnly), err)
+		} else {
+			d.render(&frame, m, now)
+		}
+		if d.terminal {
+			_, _ = io.WriteString(w, clearScreen)
+		}
+		_, _ = io.WriteString(w, frame.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *dashboard) fetch() (*balancerMetrics, error) {
+	resp, err := d.client.Get(strings.TrimSuffix(d.admin, "/") + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	m := new(balancerMetrics)
+	if err := json.NewDecoder(resp.Body).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// render writes a frame with a row per backend. RPS and error rates need a previous frame and are
+// empty in the first one. Counters lower than in the previous frame were reset by a balancer restart,
+// so they are taken as counted from zero.
+func (d *dashboard) render(w io.Writer, m *balancerMetrics, now time.Time) {
+	fmt.Fprintf(w, "%s  %s\n\n", now.Format(time.TimeOnly), d.admin)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "POOL\tBACKEND\tSTATE\tRPS\tERRORS\tP50\tP99\tIN FLIGHT\tREASON")
+
+	pools := make([]string, 0, len(m.Backends))
+	for pool := range m.Backends {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	for _, pool := range pools {
+		addresses := make([]string, 0, len(m.Backends[pool]))
+		for address := range m.Backends[pool] {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		for _, address := range addresses {
+			b := m.Backends[pool][address]
+			rps, errorRate := "-", "-"
+			if prev, ok := d.previous(pool, address); ok && now.After(d.prevAt) {
+				if b.Requests < prev.Requests || b.Errors < prev.Errors {
+					prev = backendMetrics{}
+				}
+				requests := b.Requests - prev.Requests
+				rps = fmt.Sprintf("%.1f", float64(requests)/now.Sub(d.prevAt).Seconds())
+				errorRate = "0.0%"
+				if requests > 0 {
+					errorRate = fmt.Sprintf("%.1f%%", 100*float64(b.Errors-prev.Errors)/float64(requests))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", pool, address, b.State, rps, errorRate,
+				formatLatency(b.LatencyP50), formatLatency(b.LatencyP99), m.InFlight[address], b.Reason)
+		}
+	}
+	_ = tw.Flush()
+	fmt.Fprintln(w)
+	d.prev, d.prevAt = m, now
+}
+
+// formatLatency rounds latencies for readability and shows those the balancer has too few samples
+// for, reported as zero, as missing.
+func formatLatency(latency string) string {
+	d, err := time.ParseDuration(latency)
+	switch {
+	case err != nil || d == 0:
+		return "-"
+	case d > time.Second:
+		return d.Round(time.Millisecond).String()
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func (d *dashboard) previous(pool, address string) (backendMetrics, bool) {
+	if d.prev == nil {
+		return backendMetrics{}, false
+	}
+	b, ok := d.prev.Backends[pool][address]
+	return b, ok
+}
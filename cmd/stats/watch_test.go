@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardRender(t *testing.T) {
+	d := &dashboard{admin: "http://lb:8091"}
+	m := &balancerMetrics{
+		InFlight: map[string]int64{"s1:8080": 3},
+		Backends: map[string]map[string]backendMetrics{
+			"api": {
+				"s2:8080": {State: "unhealthy", Reason: "health check failed", Requests: 50, Errors: 50},
+				"s1:8080": {State: "healthy", Requests: 100, Errors: 1, LatencyP50: "12ms", LatencyP99: "80ms"},
+			},
+		},
+	}
+	start := time.Now()
+
+	var out bytes.Buffer
+	d.render(&out, m, start)
+	lines := strings.Split(out.String(), "\n")
+	if !strings.Contains(lines[2], "RPS") || !strings.HasPrefix(lines[3], "api") || !strings.Contains(lines[3], "s1:8080") {
+		t.Fatalf("Unexpected frame:\n%s", out.String())
+	}
+	// Rates need a previous frame.
+	if fields := strings.Fields(lines[3]); fields[3] != "-" || fields[4] != "-" || fields[7] != "3" {
+		t.Errorf("Unexpected first row %q", lines[3])
+	}
+
+	next := &balancerMetrics{Backends: map[string]map[string]backendMetrics{
+		"api": {
+			"s1:8080": {State: "healthy", Requests: 120, Errors: 6, LatencyP50: "10.001234ms", LatencyP99: "90ms"},
+			"s2:8080": {State: "unhealthy", Reason: "health check failed", Requests: 50, Errors: 50},
+		},
+	}}
+	out.Reset()
+	d.render(&out, next, start.Add(2*time.Second))
+	lines = strings.Split(out.String(), "\n")
+	if fields := strings.Fields(lines[3]); fields[3] != "10.0" || fields[4] != "25.0%" || fields[5] != "10ms" || fields[6] != "90ms" {
+		t.Errorf("Unexpected row %q", lines[3])
+	}
+	if fields := strings.Fields(lines[4]); fields[3] != "0.0" || fields[4] != "0.0%" || fields[5] != "-" || !strings.Contains(lines[4], "health check failed") {
+		t.Errorf("Unexpected row %q", lines[4])
+	}
+
+	// The balancer restarted, so its counters started from zero again.
+	restarted := &balancerMetrics{Backends: map[string]map[string]backendMetrics{
+		"api": {"s1:8080": {State: "healthy", Requests: 8, Errors: 2}},
+	}}
+	out.Reset()
+	d.render(&out, restarted, start.Add(4*time.Second))
+	lines = strings.Split(out.String(), "\n")
+	if fields := strings.Fields(lines[3]); fields[3] != "4.0" || fields[4] != "25.0%" {
+		t.Errorf("Unexpected row after a reset %q", lines[3])
+	}
+}
+
+func TestWatch(t *testing.T) {
+	balancer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"inFlight": {}, "backends": {"default": {"s1:8080": {"state": "healthy", "requests": 1}}}}`))
+	}))
+	defer balancer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	watch(ctx, &dashboard{admin: balancer.URL, client: balancer.Client()}, &out, 50*time.Millisecond)
+	if frames := strings.Count(out.String(), "POOL"); frames < 2 || strings.Contains(out.String(), clearScreen) {
+		t.Errorf("Unexpected plain output:\n%s", out.String())
+	}
+
+	out.Reset()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	watch(ctx, &dashboard{admin: balancer.URL + "/missing", client: balancer.Client(), terminal: true}, &out, time.Second)
+	if !strings.HasPrefix(out.String(), clearScreen) || !strings.Contains(out.String(), "error: unexpected status 404") {
+		t.Errorf("Unexpected terminal output %q", out.String())
+	}
+}
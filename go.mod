@@ -1,13 +1,14 @@
 module github.com/roman-mazur/design-practice-2-template
 
-go 1.20
+go 1.24
 
 require github.com/stretchr/testify v1.8.3
 
+require github.com/andybalholm/brotli v1.2.6
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/jarcoal/httpmock v1.3.0
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/objx v0.5.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -11,19 +11,46 @@ type Server interface {
 	Start()
 }
 
+// Options tune the protocols a server speaks.
+type Options struct {
+	// CertFile and KeyFile enable TLS, which also lets clients negotiate HTTP/2.
+	CertFile, KeyFile string
+	// H2C enables HTTP/2 over cleartext connections (prior knowledge).
+	H2C bool
+}
+
+func (o Options) tls() bool {
+	return o.CertFile != "" && o.KeyFile != ""
+}
+
 type server struct {
 	httpServer *http.Server
+	options    Options
 }
 
 func (s server) Start() {
 	go func() {
 		log.Println("Staring the HTTP server...")
-		err := s.httpServer.ListenAndServe()
+		var err error
+		if s.options.tls() {
+			err = s.httpServer.ListenAndServeTLS(s.options.CertFile, s.options.KeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
 		log.Fatalf("HTTP server finished: %s. Finishing the process.", err)
 	}()
 }
 
 func CreateServer(port int, handler http.Handler) Server {
+	return CreateServerWithOptions(port, handler, Options{})
+}
+
+func CreateServerWithOptions(port int, handler http.Handler, options Options) Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(options.tls())
+	protocols.SetUnencryptedHTTP2(options.H2C)
+
 	return server{
 		httpServer: &http.Server{
 			Addr:           fmt.Sprintf(":%d", port),
@@ -31,6 +58,8 @@ func CreateServer(port int, handler http.Handler) Server {
 			ReadTimeout:    10 * time.Second,
 			WriteTimeout:   10 * time.Second,
 			MaxHeaderBytes: 1 << 20,
+			Protocols:      protocols,
 		},
+		options: options,
 	}
 }